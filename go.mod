@@ -3,5 +3,3 @@ module github.com/blixt/go-hotel
 go 1.23.2
 
 require github.com/gorilla/websocket v1.5.3
-
-require golang.org/x/sync v0.8.0
@@ -3,81 +3,847 @@ package hotel
 import (
 	"context"
 	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// queuedItem is a piece of data waiting in a client's buffer along with any
+// delivery constraints attached to it when it was sent.
+type queuedItem[DataType any] struct {
+	data DataType
+	// raw holds pre-encoded bytes to deliver instead of data, if non-nil.
+	raw []byte
+	// deadline is the time after which the item is stale and should be
+	// dropped instead of delivered. The zero value means no deadline.
+	deadline time.Time
+	// enqueued is when the item was sent, if latency tracking is enabled.
+	enqueued time.Time
+	// If roomGen is non-nil, the item is stale once the room's generation
+	// has moved past gen.
+	gen     uint64
+	roomGen *atomic.Uint64
+}
+
+func (item queuedItem[DataType]) expired(now time.Time) bool {
+	if item.roomGen != nil && item.gen < item.roomGen.Load() {
+		return true
+	}
+	return !item.deadline.IsZero() && now.After(item.deadline)
+}
+
+// clientRoom is the part of a Room that a client keeps track of, without
+// depending on the room's metadata type.
+type clientRoom[ClientMetadata, DataType any] interface {
+	ID() string
+	Emit(event Event[ClientMetadata, DataType])
+	RemoveClient(client *Client[ClientMetadata, DataType]) error
+}
+
+// clientOptions configures a new client based on its room and hotel.
+type clientOptions[DataType any] struct {
+	codec        Codec[DataType]
+	trackLatency bool
+	evictAfter   int
+	direct       bool
+	bufferSize   int
+	overflow     OverflowPolicy
+	logger       *slog.Logger
+	dataRate     float64
+	dataBurst    int
+	clock        Clock
+}
+
+// DefaultClientBufferSize is how many items a client buffers unless
+// configured otherwise with WithClientBuffer.
+const DefaultClientBufferSize = 256
+
+// OverflowPolicy decides what happens when data is sent to a client whose
+// buffer is full.
+type OverflowPolicy int
+
+const (
+	// OverflowDisconnect drops the data and disconnects the client, or only
+	// drops it until the client has overflowed too many times in a row (see
+	// WithEvictAfter).
+	OverflowDisconnect OverflowPolicy = iota
+	// OverflowDropNewest drops the data being sent, keeping what's buffered.
+	OverflowDropNewest
+	// OverflowDropOldest drops the oldest buffered data to make room for the
+	// new data. In direct mode, which has no buffer, it's OverflowDropNewest.
+	OverflowDropOldest
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowDisconnect:
+		return "OverflowDisconnect"
+	case OverflowDropNewest:
+		return "OverflowDropNewest"
+	case OverflowDropOldest:
+		return "OverflowDropOldest"
+	}
+	return fmt.Sprintf("<!OverflowPolicy %d>", p)
+}
+
+// Presence is a connected client's availability, as set by the client with
+// Client.SetPresence. A client that's gone has left the room instead.
+type Presence int
+
+const (
+	PresenceOnline Presence = iota
+	PresenceAway
+	PresenceBusy
+)
+
+func (p Presence) String() string {
+	switch p {
+	case PresenceOnline:
+		return "PresenceOnline"
+	case PresenceAway:
+		return "PresenceAway"
+	case PresenceBusy:
+		return "PresenceBusy"
+	}
+	return fmt.Sprintf("<!Presence %d>", p)
+}
+
 type Client[ClientMetadata, DataType any] struct {
-	metadata  *ClientMetadata
-	bufferCh  chan DataType
-	sendCh    chan DataType
-	ctx       context.Context
-	cancel    context.CancelFunc
-	closeOnce sync.Once
+	id          string
+	metadata    atomic.Pointer[ClientMetadata]
+	clock       Clock
+	connectedAt time.Time
+	codec       Codec[DataType]
+	latency     *latencyRecorder
+	logger      *slog.Logger
+	bufferCh    chan queuedItem[DataType] // Unused in direct mode.
+	direct      bool
+	pending     atomic.Int32  // Items buffered or being forwarded.
+	spaceCh     chan struct{} // Signaled when an item leaves the buffer.
+	flushMu     sync.Mutex
+	flushCh     chan struct{} // Closed when pending drops to zero, if non-nil.
+	sendCh      chan DataType
+	rawCh       chan []byte
+	ctx         context.Context
+	cancel      context.CancelCauseFunc
+	closeOnce   sync.Once
+	closeReason atomic.Pointer[LeaveReason]
+	closedAt    atomic.Int64
+
+	// userID is set by Room.NewClientForUser and never changes.
+	userID string
+
+	sent         atomic.Uint64
+	received     atomic.Uint64
+	lastActivity atomic.Int64
+	presence     atomic.Int32
+
+	overflow         OverflowPolicy
+	evictAfter       int32
+	drops            atomic.Uint64
+	consecutiveDrops atomic.Int32
+
+	bufferMu sync.RWMutex
+	drained  bool
+
+	// dataLim limits how quickly the client may send data to rooms, if set.
+	dataLimMu sync.Mutex
+	dataLim   *tokenBucket
+
+	roomMu sync.RWMutex
+	rooms  []clientRoom[ClientMetadata, DataType] // In join order.
+
+	// The client is read either through Receive() or ReceiveBytes(). Whichever
+	// is called first decides what the forwarding goroutine produces.
+	modeOnce sync.Once
+	modeCh   chan struct{}
+	rawMode  bool
 }
 
-func newClient[ClientMetadata, DataType any](metadata *ClientMetadata) *Client[ClientMetadata, DataType] {
-	ctx, cancel := context.WithCancel(context.Background())
+// clientSeq numbers the clients created by this process.
+var clientSeq atomic.Uint64
+
+func newClient[ClientMetadata, DataType any](metadata *ClientMetadata, opts clientOptions[DataType]) *Client[ClientMetadata, DataType] {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	clock := opts.clock
+	if clock == nil {
+		clock = realClock{}
+	}
 	c := &Client[ClientMetadata, DataType]{
-		metadata: metadata,
-		bufferCh: make(chan DataType, 256),
-		sendCh:   make(chan DataType),
-		ctx:      ctx,
-		cancel:   cancel,
-	}
-	// Forward event data sent to sendCh (from any goroutine) to a channel that
-	// is synchronized to a single goroutine.
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				close(c.sendCh)
+		id:          strconv.FormatUint(clientSeq.Add(1), 10),
+		clock:       clock,
+		connectedAt: clock.Now(),
+		codec:       opts.codec,
+		bufferCh:    make(chan queuedItem[DataType], opts.bufferSize),
+		sendCh:      make(chan DataType),
+		rawCh:       make(chan []byte),
+		ctx:         ctx,
+		cancel:      cancel,
+		modeCh:      make(chan struct{}),
+		spaceCh:     make(chan struct{}, 1),
+	}
+	c.metadata.Store(metadata)
+	c.evictAfter = int32(max(1, opts.evictAfter))
+	c.overflow = opts.overflow
+	c.logger = opts.logger
+	if opts.trackLatency {
+		c.latency = &latencyRecorder{}
+	}
+	if opts.dataRate > 0 {
+		c.dataLim = newTokenBucket(opts.dataRate, opts.dataBurst, c.connectedAt)
+	}
+	if opts.direct {
+		c.direct = true
+		c.bufferCh = nil
+		go c.closeDirect()
+	} else {
+		go c.forward()
+	}
+	return c
+}
+
+// closeDirect closes the receive channels of a client in direct mode once
+// it's closed. Senders write to the channels while holding the buffer read
+// lock, so they're never written to after being closed.
+func (c *Client[ClientMetadata, DataType]) closeDirect() {
+	<-c.ctx.Done()
+	c.bufferMu.Lock()
+	defer c.bufferMu.Unlock()
+	close(c.sendCh)
+	close(c.rawCh)
+}
+
+// forward moves event data sent to bufferCh (from any goroutine) to a channel
+// that is synchronized to a single goroutine.
+func (c *Client[ClientMetadata, DataType]) forward() {
+	defer close(c.sendCh)
+	defer close(c.rawCh)
+
+	// Nothing can be delivered until the consumer has picked a receive
+	// channel, so wait for that first.
+	select {
+	case <-c.ctx.Done():
+		return
+	case <-c.modeCh:
+	}
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case item := <-c.bufferCh:
+			c.signalSpace()
+			delivered := c.deliver(item)
+			c.release()
+			if !delivered {
 				return
-			case data := <-c.bufferCh:
-				// Forwarding to sendCh will always block until the user code
-				// has read from the Receive() channel. If the buffer channel
-				// fills up, then the send method will close the client, which
-				// is why we also check the context here.
-				select {
-				case <-ctx.Done():
-					close(c.sendCh)
-					return
-				case c.sendCh <- data:
-					// All good, keep going.
-				}
 			}
 		}
-	}()
-	return c
+	}
+}
+
+// deliver passes a buffered item on to the consumer. It returns false if the
+// client was closed first.
+func (c *Client[ClientMetadata, DataType]) deliver(item queuedItem[DataType]) bool {
+	// Messages that went stale (by time or by room generation) while sitting
+	// in the buffer are dropped so that slow clients don't replay outdated
+	// data.
+	if item.expired(time.Now()) {
+		return true
+	}
+	// Forwarding will always block until the user code has read from the
+	// receive channel. If the buffer channel fills up, then the send method
+	// will close the client, which is why we also check the context here.
+	if c.rawMode {
+		raw, err := c.encode(item)
+		if err != nil {
+			c.logger.Error("failed to encode data for client", "client.id", c.id, "error", err)
+			return true
+		}
+		select {
+		case <-c.ctx.Done():
+			return false
+		case c.rawCh <- raw:
+			c.recordSent(item)
+		}
+	} else {
+		data, err := c.decode(item)
+		if err != nil {
+			c.logger.Error("failed to decode data for client", "client.id", c.id, "error", err)
+			return true
+		}
+		select {
+		case <-c.ctx.Done():
+			return false
+		case c.sendCh <- data:
+			c.recordSent(item)
+		}
+	}
+	return true
+}
+
+// recordSent is called when the item was handed to the consumer.
+func (c *Client[ClientMetadata, DataType]) recordSent(item queuedItem[DataType]) {
+	c.sent.Add(1)
+	if c.latency != nil {
+		c.latency.record(time.Since(item.enqueued))
+	}
+}
+
+// SendLatency summarizes how long recent messages waited between being sent
+// to the client and being read by the consumer, which excludes any time spent
+// on the network. It's only tracked if the hotel was created with
+// WithLatencyTracking, otherwise the zero value is returned.
+func (c *Client[ClientMetadata, DataType]) SendLatency() LatencyStats {
+	if c.latency == nil {
+		return LatencyStats{}
+	}
+	return c.latency.stats()
+}
+
+func (c *Client[ClientMetadata, DataType]) encode(item queuedItem[DataType]) ([]byte, error) {
+	if item.raw != nil {
+		return item.raw, nil
+	}
+	if c.codec == nil {
+		return nil, errors.New("no codec configured")
+	}
+	return c.codec.Encode(item.data)
+}
+
+func (c *Client[ClientMetadata, DataType]) decode(item queuedItem[DataType]) (DataType, error) {
+	if item.raw == nil {
+		return item.data, nil
+	}
+	if c.codec == nil {
+		var zero DataType
+		return zero, errors.New("no codec configured")
+	}
+	return c.codec.Decode(item.raw)
 }
 
 func (c *Client[ClientMetadata, DataType]) Context() context.Context {
 	return c.ctx
 }
 
+// ID returns the client's ID, which is unique among the clients created by
+// this process, e.g. for correlating logs and metrics.
+func (c *Client[ClientMetadata, DataType]) ID() string {
+	return c.id
+}
+
+// CloseReason returns why the client was closed, and false if it hasn't been
+// closed yet. It's set before the receive channel closes, so a transport can
+// read it once Receive is drained, e.g. to send a WebSocket close frame.
+func (c *Client[ClientMetadata, DataType]) CloseReason() (LeaveReason, bool) {
+	if reason := c.closeReason.Load(); reason != nil {
+		return *reason, true
+	}
+	return "", false
+}
+
 func (c *Client[ClientMetadata, DataType]) Metadata() *ClientMetadata {
-	return c.metadata
+	return c.metadata.Load()
+}
+
+// SwapMetadata atomically replaces the client's metadata and returns the
+// previous metadata. Readers of Metadata will see either the old or the new
+// metadata, never a mix, as long as the fields of a metadata value are not
+// mutated in place after it has been set. Like in NewClient, the metadata
+// must not be nil; if it is, nothing changes and the current metadata is
+// returned. An EventMetadataChanged is emitted in every room the client is in
+// so the handlers can react to the change.
+func (c *Client[ClientMetadata, DataType]) SwapMetadata(metadata *ClientMetadata) (old *ClientMetadata) {
+	if metadata == nil {
+		return c.metadata.Load()
+	}
+	old = c.metadata.Swap(metadata)
+	for _, room := range c.roomList() {
+		room.Emit(Event[ClientMetadata, DataType]{
+			Type:   EventMetadataChanged,
+			Client: c,
+		})
+	}
+	return old
+}
+
+// Presence returns the client's presence, which starts out as PresenceOnline.
+func (c *Client[ClientMetadata, DataType]) Presence() Presence {
+	return Presence(c.presence.Load())
+}
+
+// SetPresence changes the client's presence and returns the previous one. If
+// it changed, an EventPresence is emitted in every room the client is in.
+func (c *Client[ClientMetadata, DataType]) SetPresence(presence Presence) (old Presence) {
+	old = Presence(c.presence.Swap(int32(presence)))
+	if old == presence {
+		return old
+	}
+	for _, room := range c.roomList() {
+		room.Emit(Event[ClientMetadata, DataType]{
+			Type:         EventPresence,
+			Client:       c,
+			Presence:     presence,
+			PrevPresence: old,
+		})
+	}
+	return old
+}
+
+// ConnectedAt returns when the client was created, i.e. when it joined.
+func (c *Client[ClientMetadata, DataType]) ConnectedAt() time.Time {
+	return c.connectedAt
+}
+
+// LastActivity returns when the client last sent data to a room, or when it
+// was created if it hasn't sent anything.
+func (c *Client[ClientMetadata, DataType]) LastActivity() time.Time {
+	if t := c.lastActivity.Load(); t != 0 {
+		return time.Unix(0, t)
+	}
+	return c.connectedAt
+}
+
+// allowData reports whether the client may send more data to a room without
+// exceeding its rate limit.
+func (c *Client[ClientMetadata, DataType]) allowData() bool {
+	if c.dataLim == nil {
+		return true
+	}
+	c.dataLimMu.Lock()
+	defer c.dataLimMu.Unlock()
+	return c.dataLim.allow(time.Now())
+}
+
+// recordReceived is called when a room accepted data from the client.
+func (c *Client[ClientMetadata, DataType]) recordReceived() {
+	c.received.Add(1)
+	c.lastActivity.Store(c.clock.Now().UnixNano())
+}
+
+// RoomOf returns the room the client currently belongs to, or nil if it has
+// been removed from its room. If the client is in several rooms, the one it
+// joined first is returned. This is a function rather than a method on Client
+// because clients don't know the type of their room's metadata, so it has to
+// be provided, e.g. RoomOf[MyRoomMetadata](client).
+func RoomOf[RoomMetadata, ClientMetadata, DataType any](client *Client[ClientMetadata, DataType]) *Room[RoomMetadata, ClientMetadata, DataType] {
+	for _, room := range client.roomList() {
+		if room, ok := room.(*Room[RoomMetadata, ClientMetadata, DataType]); ok {
+			return room
+		}
+	}
+	return nil
 }
 
-func (c *Client[ClientMetadata, DataType]) send(data DataType) error {
+// RoomsOf is like RoomOf, but returns every room the client is in, in the
+// order it joined them.
+func RoomsOf[RoomMetadata, ClientMetadata, DataType any](client *Client[ClientMetadata, DataType]) []*Room[RoomMetadata, ClientMetadata, DataType] {
+	var rooms []*Room[RoomMetadata, ClientMetadata, DataType]
+	for _, room := range client.roomList() {
+		if room, ok := room.(*Room[RoomMetadata, ClientMetadata, DataType]); ok {
+			rooms = append(rooms, room)
+		}
+	}
+	return rooms
+}
+
+// roomList returns a snapshot of the rooms the client is in. The slice is
+// replaced rather than modified, so it can be used without holding the lock.
+func (c *Client[ClientMetadata, DataType]) roomList() []clientRoom[ClientMetadata, DataType] {
+	c.roomMu.RLock()
+	defer c.roomMu.RUnlock()
+	return c.rooms
+}
+
+func (c *Client[ClientMetadata, DataType]) inRoom(room clientRoom[ClientMetadata, DataType]) bool {
+	return slices.Contains(c.roomList(), room)
+}
+
+func (c *Client[ClientMetadata, DataType]) addRoom(room clientRoom[ClientMetadata, DataType]) {
+	c.roomMu.Lock()
+	defer c.roomMu.Unlock()
+	if !slices.Contains(c.rooms, room) {
+		c.rooms = append(slices.Clip(c.rooms), room)
+	}
+}
+
+// removeRoom removes the room from the client's rooms and reports whether the
+// client is still in any room.
+func (c *Client[ClientMetadata, DataType]) removeRoom(room clientRoom[ClientMetadata, DataType]) (inOtherRooms bool) {
+	c.roomMu.Lock()
+	defer c.roomMu.Unlock()
+	if i := slices.Index(c.rooms, room); i >= 0 {
+		c.rooms = slices.Delete(slices.Clone(c.rooms), i, i+1)
+	}
+	return len(c.rooms) > 0
+}
+
+// Send queues data for the client directly, without going through a room, so
+// it's not subject to room membership checks. Otherwise it behaves like
+// Room.SendToClient: it returns the same errors when the client is full,
+// drained or closed, and a client whose buffer stayed full for too long (see
+// WithEvictAfter) is closed with ReasonBufferFull, which removes it from all
+// of its rooms.
+func (c *Client[ClientMetadata, DataType]) Send(data DataType) error {
+	return c.enqueue(queuedItem[DataType]{data: data})
+}
+
+// enqueue is like put, but closes the client if its buffer stayed full for
+// too long.
+func (c *Client[ClientMetadata, DataType]) enqueue(item queuedItem[DataType]) error {
+	err := c.put(item)
+	if errors.Is(err, ErrBufferFull) {
+		c.CloseWithReason(ReasonBufferFull)
+	}
+	return err
+}
+
+// put queues the item for the client. If the buffer is full the item is
+// dropped, and ErrBufferFull is returned once the buffer stayed full for long
+// enough that the client should be evicted (see WithEvictAfter), but put
+// never closes the client itself.
+func (c *Client[ClientMetadata, DataType]) put(item queuedItem[DataType]) error {
+	if c.latency != nil {
+		item.enqueued = time.Now()
+	}
+	// Any number of sends may happen concurrently, but operations that empty
+	// the buffer must not race with them.
+	c.bufferMu.RLock()
+	defer c.bufferMu.RUnlock()
+	if c.drained {
+		return ErrClientDrained
+	}
+	if c.direct {
+		if ok, err := c.offerDirect(item); ok || err != nil {
+			return err
+		}
+		return c.dropped()
+	}
+	if ok, err := c.offer(item); ok || err != nil {
+		return err
+	}
+	if c.overflow == OverflowDropOldest {
+		// Make room by taking the oldest item, unless the forwarding goroutine
+		// or another sender got to the buffer first.
+		select {
+		case <-c.bufferCh:
+			c.release()
+			c.drops.Add(1)
+		default:
+		}
+		if ok, err := c.offer(item); ok || err != nil {
+			return err
+		}
+	}
+	// Channel is full, so the data is dropped.
+	return c.dropped()
+}
+
+// enqueueWait is like enqueue, but waits for room for the item instead of
+// dropping it, until ctx is done.
+func (c *Client[ClientMetadata, DataType]) enqueueWait(ctx context.Context, item queuedItem[DataType]) error {
+	if c.latency != nil {
+		item.enqueued = time.Now()
+	}
+	// In direct mode there's no buffer to make room in, so keep retrying
+	// until the consumer is ready to receive.
+	var retry <-chan time.Time
+	if c.direct {
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		retry = ticker.C
+	}
+	for {
+		c.bufferMu.RLock()
+		var ok bool
+		var err error
+		switch {
+		case c.drained:
+			err = ErrClientDrained
+		case c.direct:
+			ok, err = c.offerDirect(item)
+		default:
+			ok, err = c.offer(item)
+		}
+		c.bufferMu.RUnlock()
+		if ok || err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.ctx.Done():
+			return errors.New("client disconnected")
+		case <-c.spaceCh:
+		case <-retry:
+		}
+	}
+}
+
+// offer puts the item in the buffer if there's room, and reports whether it
+// did. The caller must hold the buffer read lock.
+func (c *Client[ClientMetadata, DataType]) offer(item queuedItem[DataType]) (bool, error) {
+	// The select below picks at random if the buffer also has room.
+	if c.ctx.Err() != nil {
+		return false, errors.New("client disconnected")
+	}
+	// Counted before sending so that the forwarding goroutine can never see
+	// the item before it's counted.
+	c.pending.Add(1)
 	select {
 	case <-c.ctx.Done():
-		return errors.New("client disconnected")
-	case c.bufferCh <- data:
-		return nil
+		c.release()
+		return false, errors.New("client disconnected")
+	case c.bufferCh <- item:
+		c.delivered()
+		return true, nil
+	default:
+		c.release()
+		return false, nil
+	}
+}
+
+// offerDirect hands the item straight to the consumer if it's currently
+// waiting to receive, and reports whether it did. The caller must hold the
+// buffer read lock.
+func (c *Client[ClientMetadata, DataType]) offerDirect(item queuedItem[DataType]) (bool, error) {
+	if c.ctx.Err() != nil {
+		return false, errors.New("client disconnected")
+	}
+	select {
+	case <-c.modeCh:
+	default:
+		// The consumer hasn't started receiving yet.
+		return false, nil
+	}
+	if c.rawMode {
+		raw, err := c.encode(item)
+		if err != nil {
+			return false, err
+		}
+		select {
+		case c.rawCh <- raw:
+		default:
+			return false, nil
+		}
+	} else {
+		data, err := c.decode(item)
+		if err != nil {
+			return false, err
+		}
+		select {
+		case c.sendCh <- data:
+		default:
+			return false, nil
+		}
+	}
+	c.recordSent(item)
+	c.delivered()
+	return true, nil
+}
+
+// signalSpace wakes up a sender waiting for room in the buffer, if any.
+func (c *Client[ClientMetadata, DataType]) signalSpace() {
+	select {
+	case c.spaceCh <- struct{}{}:
 	default:
-		// Channel is full, disconnect the client
-		c.Close()
-		return errors.New("send channel full, client disconnected")
 	}
 }
 
+func (c *Client[ClientMetadata, DataType]) delivered() {
+	if c.consecutiveDrops.Load() != 0 {
+		c.consecutiveDrops.Store(0)
+	}
+}
+
+// dropped records that data for the client was dropped, and returns
+// ErrBufferFull if this keeps happening.
+func (c *Client[ClientMetadata, DataType]) dropped() error {
+	c.drops.Add(1)
+	if c.overflow != OverflowDisconnect || c.consecutiveDrops.Add(1) < c.evictAfter {
+		return ErrDataDropped
+	}
+	return ErrBufferFull
+}
+
+// flushed reports whether everything sent to the client has been handed to
+// its consumer, which is also true once it's closed.
+func (c *Client[ClientMetadata, DataType]) flushed() bool {
+	return c.ctx.Err() != nil || c.pending.Load() == 0
+}
+
+// release counts an item as no longer pending, and wakes up anyone waiting in
+// waitFlushed if it was the last one.
+func (c *Client[ClientMetadata, DataType]) release() {
+	if c.pending.Add(-1) != 0 {
+		return
+	}
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
+	if c.flushCh != nil {
+		close(c.flushCh)
+		c.flushCh = nil
+	}
+}
+
+// waitFlushed waits until the client is flushed, or until ctx is done.
+func (c *Client[ClientMetadata, DataType]) waitFlushed(ctx context.Context) error {
+	for {
+		// The channel is taken before checking, so that the buffer emptying
+		// right after the check still wakes this up.
+		c.flushMu.Lock()
+		if c.flushCh == nil {
+			c.flushCh = make(chan struct{})
+		}
+		flushCh := c.flushCh
+		c.flushMu.Unlock()
+		if c.flushed() {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.ctx.Done():
+			return nil
+		case <-flushCh:
+		}
+	}
+}
+
+// nearlyFull reports whether the client's buffer is at least three quarters
+// full.
+func (c *Client[ClientMetadata, DataType]) nearlyFull() bool {
+	return int(c.pending.Load()) >= cap(c.bufferCh)*3/4 && !c.direct
+}
+
+// DropCount returns how many times data sent to the client was dropped
+// because its buffer was full.
+func (c *Client[ClientMetadata, DataType]) DropCount() uint64 {
+	return c.drops.Load()
+}
+
+// Drain stops the client from accepting any more data and returns the data
+// that was still buffered, e.g. to hand it off to a replacement connection.
+// The client is otherwise left alive and in its room. Data that had already
+// been taken from the buffer to be delivered on the receive channel is not
+// returned.
+func (c *Client[ClientMetadata, DataType]) Drain() []DataType {
+	c.bufferMu.Lock()
+	defer c.bufferMu.Unlock()
+	c.drained = true
+	var drained []DataType
+	now := time.Now()
+	for {
+		select {
+		case item := <-c.bufferCh:
+			c.release()
+			if item.expired(now) {
+				continue
+			}
+			data, err := c.decode(item)
+			if err != nil {
+				c.logger.Error("failed to decode data for client", "client.id", c.id, "error", err)
+				continue
+			}
+			drained = append(drained, data)
+		default:
+			c.signalSpace()
+			return drained
+		}
+	}
+}
+
+// PurgeQueued removes the data waiting in the client's buffer for which
+// predicate returns true, e.g. privileged messages after the client lost its
+// permissions, and returns how many items it removed. The client stays open.
+// The remaining items keep their order, except that an item the client takes
+// from the buffer while the purge is running may be delivered ahead of items
+// queued before it. Data already handed to the consumer can't be purged.
+func (c *Client[ClientMetadata, DataType]) PurgeQueued(predicate func(data DataType) bool) int {
+	// The write lock keeps senders out, so there's always room to put the
+	// remaining items back.
+	c.bufferMu.Lock()
+	defer c.bufferMu.Unlock()
+	var kept []queuedItem[DataType]
+	purged := 0
+	now := time.Now()
+loop:
+	for {
+		select {
+		case item := <-c.bufferCh:
+			if item.expired(now) {
+				c.release()
+				continue
+			}
+			data, err := c.decode(item)
+			if err == nil && predicate(data) {
+				c.release()
+				purged++
+				continue
+			}
+			kept = append(kept, item)
+		default:
+			break loop
+		}
+	}
+	for _, item := range kept {
+		c.bufferCh <- item
+	}
+	if purged > 0 {
+		c.signalSpace()
+	}
+	return purged
+}
+
 func (c *Client[ClientMetadata, DataType]) Receive() <-chan DataType {
-	// Return the channel that only the internal client goroutine writes to.
+	c.modeOnce.Do(func() {
+		close(c.modeCh)
+	})
+	// Return the channel that only the internal client goroutine (or, in
+	// direct mode, senders holding the buffer lock) writes to.
 	return c.sendCh
 }
 
+// ReceiveBytes is like Receive, but yields encoded bytes for transports that
+// just want to write them out. Data sent as raw bytes is passed through as-is
+// and everything else is encoded with the room's codec. A client should only
+// be read through one of Receive and ReceiveBytes; the other channel will
+// only ever be closed.
+func (c *Client[ClientMetadata, DataType]) ReceiveBytes() <-chan []byte {
+	c.modeOnce.Do(func() {
+		c.rawMode = true
+		close(c.modeCh)
+	})
+	return c.rawCh
+}
+
+// Close disconnects the client and removes it from every room it's in, so
+// that a closed client is never left listed in a room.
 func (c *Client[ClientMetadata, DataType]) Close() {
+	c.CloseWithReason(ReasonClosed)
+}
+
+// CloseWithReason is like Close, but records why the client was closed, which
+// is then available through CloseReason. Only the first close of a client
+// records a reason.
+func (c *Client[ClientMetadata, DataType]) CloseWithReason(reason LeaveReason) {
+	closed := false
 	c.closeOnce.Do(func() {
-		c.cancel()
+		c.closeReason.Store(&reason)
+		c.closedAt.Store(time.Now().UnixNano())
+		c.cancel(reason)
+		closed = true
 	})
+	if !closed {
+		return
+	}
+	// This happens outside of closeOnce because RemoveClient closes the
+	// client too.
+	for _, room := range c.roomList() {
+		room.RemoveClient(c)
+	}
 }
@@ -0,0 +1,88 @@
+package hotel
+
+import (
+	"errors"
+	"iter"
+)
+
+// ClientSet is a set of clients. The zero value is an empty set ready to use,
+// and a nil *ClientSet can be read from as an empty set.
+type ClientSet[ClientMetadata, DataType any] struct {
+	clients map[*Client[ClientMetadata, DataType]]struct{}
+}
+
+// NewClientSet returns a set containing the given clients.
+func NewClientSet[ClientMetadata, DataType any](clients ...*Client[ClientMetadata, DataType]) *ClientSet[ClientMetadata, DataType] {
+	s := &ClientSet[ClientMetadata, DataType]{}
+	s.Add(clients...)
+	return s
+}
+
+func (s *ClientSet[ClientMetadata, DataType]) Add(clients ...*Client[ClientMetadata, DataType]) {
+	if s.clients == nil {
+		s.clients = make(map[*Client[ClientMetadata, DataType]]struct{}, len(clients))
+	}
+	for _, client := range clients {
+		s.clients[client] = struct{}{}
+	}
+}
+
+func (s *ClientSet[ClientMetadata, DataType]) Remove(client *Client[ClientMetadata, DataType]) {
+	if s != nil {
+		delete(s.clients, client)
+	}
+}
+
+func (s *ClientSet[ClientMetadata, DataType]) Contains(client *Client[ClientMetadata, DataType]) bool {
+	if s == nil {
+		return false
+	}
+	_, ok := s.clients[client]
+	return ok
+}
+
+func (s *ClientSet[ClientMetadata, DataType]) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.clients)
+}
+
+// All returns an iterator over the clients in the set, in no particular
+// order.
+func (s *ClientSet[ClientMetadata, DataType]) All() iter.Seq[*Client[ClientMetadata, DataType]] {
+	return func(yield func(*Client[ClientMetadata, DataType]) bool) {
+		if s == nil {
+			return
+		}
+		for client := range s.clients {
+			if !yield(client) {
+				return
+			}
+		}
+	}
+}
+
+// BroadcastExceptSet sends data to every client in the room that isn't in
+// except.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) BroadcastExceptSet(except *ClientSet[ClientMetadata, DataType], data DataType) {
+	if r.broadcastLimited() {
+		return
+	}
+	r.recordHistory(data)
+	r.broadcast(func(c *Client[ClientMetadata, DataType]) bool {
+		return !except.Contains(c)
+	}, queuedItem[DataType]{data: data})
+}
+
+// SendToClients sends data to each client in the set, like SendToClient, and
+// returns the errors for the clients it couldn't send to.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) SendToClients(clients *ClientSet[ClientMetadata, DataType], data DataType) error {
+	var errs []error
+	for client := range clients.All() {
+		if err := r.SendToClient(client, data); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,319 @@
+package hotel
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Codec converts values to and from the bytes sent over a transport.
+type Codec[M any] interface {
+	Encode(msg M) ([]byte, error)
+	Decode(data []byte) (M, error)
+	// Binary reports whether encoded data may not be valid UTF-8, which lets
+	// transports such as WebSockets pick between text and binary frames.
+	Binary() bool
+}
+
+// LimitedDecoder can be implemented by a Codec whose decoded frames can be
+// larger than the frames it's given, e.g. because they're compressed, so that
+// Room.HandleClientBytes can apply WithMaxMessageSize to the decoded size as
+// well.
+type LimitedDecoder[M any] interface {
+	// DecodeLimit is like Decode, but fails with ErrMessageTooLarge instead
+	// of producing more than limit bytes while decoding.
+	DecodeLimit(data []byte, limit int) (M, error)
+}
+
+type Compression byte
+
+// Compressed frames start with a single byte identifying the algorithm. These
+// values are control characters that can never start a message type name, so
+// uncompressed frames are unaffected.
+const (
+	CompressionNone Compression = iota
+	CompressionFlate
+	CompressionGzip
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "CompressionNone"
+	case CompressionFlate:
+		return "CompressionFlate"
+	case CompressionGzip:
+		return "CompressionGzip"
+	}
+	return fmt.Sprintf("<!Compression %d>", c)
+}
+
+// JSONCodec encodes messages as their type name followed by a space and the
+// JSON payload, e.g. `chat {"content":"hi"}`. The message type is looked up in
+// the registry when decoding.
+type JSONCodec[M Message] struct {
+	registry    MessageRegistry[M]
+	compression Compression
+	threshold   int
+}
+
+type JSONCodecOption func(*jsonCodecConfig)
+
+type jsonCodecConfig struct {
+	compression Compression
+	threshold   int
+}
+
+// WithCompression makes the codec compress frames larger than threshold bytes
+// using the given algorithm. Smaller frames are sent as-is to avoid overhead.
+func WithCompression(algorithm Compression, threshold int) JSONCodecOption {
+	return func(c *jsonCodecConfig) {
+		c.compression = algorithm
+		c.threshold = threshold
+	}
+}
+
+func NewJSONCodec[M Message](registry MessageRegistry[M], opts ...JSONCodecOption) *JSONCodec[M] {
+	var config jsonCodecConfig
+	for _, opt := range opts {
+		opt(&config)
+	}
+	return &JSONCodec[M]{
+		registry:    registry,
+		compression: config.compression,
+		threshold:   config.threshold,
+	}
+}
+
+// Binary reports true if compression is enabled, since compressed frames are
+// not valid text.
+func (c *JSONCodec[M]) Binary() bool {
+	return c.compression != CompressionNone
+}
+
+func (c *JSONCodec[M]) Encode(msg M) ([]byte, error) {
+	return c.EncodeJSON(msg.Type(), msg)
+}
+
+// EncodeJSON frames any JSON-serializable value with the given type name,
+// without requiring the type to be registered. Note that decoding the result
+// still requires the type to be registered.
+func (c *JSONCodec[M]) EncodeJSON(msgType string, v any) ([]byte, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("marshal error: %w", err)
+	}
+	frame := make([]byte, 0, len(msgType)+1+len(payload))
+	frame = append(frame, msgType...)
+	frame = append(frame, ' ')
+	frame = append(frame, payload...)
+	if c.compression == CompressionNone || len(frame) <= c.threshold {
+		return frame, nil
+	}
+	return compress(c.compression, frame)
+}
+
+func (c *JSONCodec[M]) Decode(data []byte) (msg M, err error) {
+	return c.DecodeLimit(data, 0)
+}
+
+// DecodeLimit is like Decode, but fails with ErrMessageTooLarge if a
+// compressed frame inflates to more than limit bytes. A limit of zero or less
+// means no limit.
+func (c *JSONCodec[M]) DecodeLimit(data []byte, limit int) (msg M, err error) {
+	if len(data) > 0 && (data[0] == byte(CompressionFlate) || data[0] == byte(CompressionGzip)) {
+		if data, err = decompress(Compression(data[0]), data[1:], limit); err != nil {
+			return
+		}
+	}
+	msgType, payload, ok := bytes.Cut(data, []byte{' '})
+	if !ok {
+		err = fmt.Errorf("invalid message format: %q", data)
+		return
+	}
+	if msg, err = c.registry.Create(string(msgType)); err != nil {
+		err = fmt.Errorf("message creation error: %w", err)
+		return
+	}
+	if err = json.Unmarshal(payload, msg); err != nil {
+		err = fmt.Errorf("unmarshal error: %w", err)
+		return
+	}
+	return msg, nil
+}
+
+// BinaryCodec encodes messages as their type name followed by a space and the
+// payload from the message's MarshalBinary method. Registered messages must
+// implement encoding.BinaryMarshaler and encoding.BinaryUnmarshaler, which is
+// also how to plug in another serialization format. For protobuf, see
+// ProtoCodec.
+type BinaryCodec[M Message] struct {
+	registry MessageRegistry[M]
+}
+
+func NewBinaryCodec[M Message](registry MessageRegistry[M]) *BinaryCodec[M] {
+	return &BinaryCodec[M]{registry: registry}
+}
+
+// Binary reports true, since payloads are arbitrary bytes.
+func (c *BinaryCodec[M]) Binary() bool {
+	return true
+}
+
+func (c *BinaryCodec[M]) Encode(msg M) ([]byte, error) {
+	marshaler, ok := any(msg).(encoding.BinaryMarshaler)
+	if !ok {
+		return nil, fmt.Errorf("message type %q does not implement encoding.BinaryMarshaler", msg.Type())
+	}
+	payload, err := marshaler.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("marshal error: %w", err)
+	}
+	return binaryFrame(msg.Type(), payload), nil
+}
+
+func (c *BinaryCodec[M]) Decode(data []byte) (msg M, err error) {
+	msg, payload, err := createFromFrame(c.registry, data)
+	if err != nil {
+		return
+	}
+	unmarshaler, ok := any(msg).(encoding.BinaryUnmarshaler)
+	if !ok {
+		err = fmt.Errorf("message type %q does not implement encoding.BinaryUnmarshaler", msg.Type())
+		return
+	}
+	if err = unmarshaler.UnmarshalBinary(payload); err != nil {
+		err = fmt.Errorf("unmarshal error: %w", err)
+		return
+	}
+	return msg, nil
+}
+
+// ProtoMessage is a message with the Marshal and Unmarshal methods that
+// protobuf code generators such as gogo/protobuf add to the generated types.
+// It mirrors those methods so that this package doesn't have to depend on a
+// protobuf library.
+type ProtoMessage interface {
+	Message
+	Marshal() ([]byte, error)
+	Unmarshal(data []byte) error
+}
+
+// ProtoCodec encodes protobuf messages as their type name followed by a space
+// and the message's protobuf wire format. Registered messages must implement
+// ProtoMessage; for types generated without those methods (e.g. by
+// protoc-gen-go), add them by calling proto.Marshal and proto.Unmarshal.
+type ProtoCodec[M ProtoMessage] struct {
+	registry MessageRegistry[M]
+}
+
+func NewProtoCodec[M ProtoMessage](registry MessageRegistry[M]) *ProtoCodec[M] {
+	return &ProtoCodec[M]{registry: registry}
+}
+
+// Binary reports true, since the protobuf wire format is not text.
+func (c *ProtoCodec[M]) Binary() bool {
+	return true
+}
+
+func (c *ProtoCodec[M]) Encode(msg M) ([]byte, error) {
+	payload, err := msg.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("marshal error: %w", err)
+	}
+	return binaryFrame(msg.Type(), payload), nil
+}
+
+func (c *ProtoCodec[M]) Decode(data []byte) (msg M, err error) {
+	msg, payload, err := createFromFrame(c.registry, data)
+	if err != nil {
+		return
+	}
+	if err = msg.Unmarshal(payload); err != nil {
+		err = fmt.Errorf("unmarshal error: %w", err)
+		return
+	}
+	return msg, nil
+}
+
+// binaryFrame prefixes the payload with the message type and a space.
+func binaryFrame(msgType string, payload []byte) []byte {
+	frame := make([]byte, 0, len(msgType)+1+len(payload))
+	frame = append(frame, msgType...)
+	frame = append(frame, ' ')
+	return append(frame, payload...)
+}
+
+// createFromFrame splits a frame made by binaryFrame and creates a new message
+// of its type, returning it along with the payload to unmarshal into it.
+func createFromFrame[M Message](registry MessageRegistry[M], data []byte) (msg M, payload []byte, err error) {
+	msgType, payload, ok := bytes.Cut(data, []byte{' '})
+	if !ok {
+		err = fmt.Errorf("invalid message format: %q", data)
+		return
+	}
+	if msg, err = registry.Create(string(msgType)); err != nil {
+		err = fmt.Errorf("message creation error: %w", err)
+		return
+	}
+	return msg, payload, nil
+}
+
+func compress(algorithm Compression, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(algorithm))
+	var w io.WriteCloser
+	switch algorithm {
+	case CompressionFlate:
+		// Only errors on an invalid level, which DefaultCompression isn't.
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	case CompressionGzip:
+		w = gzip.NewWriter(&buf)
+	default:
+		return nil, fmt.Errorf("unsupported compression: %s", algorithm)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, fmt.Errorf("compression error: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("compression error: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress inflates data, failing with ErrMessageTooLarge if the result is
+// larger than limit, unless limit is zero or less.
+func decompress(algorithm Compression, data []byte, limit int) ([]byte, error) {
+	var r io.ReadCloser
+	switch algorithm {
+	case CompressionFlate:
+		r = flate.NewReader(bytes.NewReader(data))
+	case CompressionGzip:
+		var err error
+		if r, err = gzip.NewReader(bytes.NewReader(data)); err != nil {
+			return nil, fmt.Errorf("decompression error: %w", err)
+		}
+	default:
+		return nil, errors.New("unsupported compression")
+	}
+	defer r.Close()
+	var src io.Reader = r
+	if limit > 0 {
+		// Reading one byte past the limit tells a frame that's exactly at
+		// the limit apart from one that's over it.
+		src = io.LimitReader(r, int64(limit)+1)
+	}
+	out, err := io.ReadAll(src)
+	if err != nil {
+		return nil, fmt.Errorf("decompression error: %w", err)
+	}
+	if limit > 0 && len(out) > limit {
+		return nil, ErrMessageTooLarge
+	}
+	return out, nil
+}
@@ -0,0 +1,259 @@
+package hotel
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type testChatMessage struct {
+	Content string `json:"content"`
+}
+
+func (m testChatMessage) Type() string {
+	return "chat"
+}
+
+func TestJSONCodecCompression(t *testing.T) {
+	registry := MessageRegistry[Message]{}
+	registry.Register(&testChatMessage{})
+
+	for _, algorithm := range []Compression{CompressionNone, CompressionFlate, CompressionGzip} {
+		t.Run(algorithm.String(), func(t *testing.T) {
+			codec := NewJSONCodec(registry, WithCompression(algorithm, 64))
+
+			small, err := codec.Encode(&testChatMessage{Content: "hi"})
+			if err != nil {
+				t.Fatalf("Encode failed: %v", err)
+			}
+			if string(small) != `chat {"content":"hi"}` {
+				t.Errorf("small message should not be compressed, got %q", small)
+			}
+
+			content := strings.Repeat("hello ", 100)
+			large, err := codec.Encode(&testChatMessage{Content: content})
+			if err != nil {
+				t.Fatalf("Encode failed: %v", err)
+			}
+			if algorithm != CompressionNone && large[0] != byte(algorithm) {
+				t.Errorf("large message should be compressed with %s, got first byte %d", algorithm, large[0])
+			}
+
+			for _, frame := range [][]byte{small, large} {
+				msg, err := codec.Decode(frame)
+				if err != nil {
+					t.Fatalf("Decode failed: %v", err)
+				}
+				if _, ok := msg.(*testChatMessage); !ok {
+					t.Errorf("expected *testChatMessage, got %T", msg)
+				}
+			}
+		})
+	}
+}
+
+func TestMessageRegistryCodec(t *testing.T) {
+	registry := MessageRegistry[Message]{}
+	registry.Register(&testChatMessage{})
+	var codec Codec[Message] = registry
+
+	frame, err := codec.Encode(&testChatMessage{Content: "hi"})
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if string(frame) != `chat {"content":"hi"}` {
+		t.Errorf("unexpected frame %q", frame)
+	}
+	msg, err := codec.Decode(frame)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if chat, ok := msg.(*testChatMessage); !ok || chat.Content != "hi" {
+		t.Errorf("expected chat message with content hi, got %#v", msg)
+	}
+	for _, frame := range []string{"chat", `nope {}`, `chat {`} {
+		if _, err := codec.Decode([]byte(frame)); err == nil {
+			t.Errorf("expected error decoding %q", frame)
+		}
+	}
+}
+
+type testJoinMessage struct{}
+
+func (m testJoinMessage) Type() string {
+	return "join"
+}
+
+func TestMessageRegistryRegisterErr(t *testing.T) {
+	registry := MessageRegistry[Message]{}
+	if err := registry.RegisterErr(&testChatMessage{}); err != nil {
+		t.Fatalf("RegisterErr failed: %v", err)
+	}
+	if err := registry.RegisterErr(&testJoinMessage{}, &testChatMessage{}); err == nil {
+		t.Error("expected error registering chat twice")
+	}
+	if registry.Has("join") {
+		t.Error("expected join not to be registered after a conflict")
+	}
+	registry.Register(&testJoinMessage{})
+	if got := strings.Join(registry.Types(), ","); got != "chat,join" {
+		t.Errorf("expected types chat,join, got %s", got)
+	}
+}
+
+type testPointMessage struct {
+	X, Y byte
+}
+
+func (m *testPointMessage) Type() string {
+	return "point"
+}
+
+func (m *testPointMessage) MarshalBinary() ([]byte, error) {
+	return []byte{m.X, m.Y}, nil
+}
+
+func (m *testPointMessage) UnmarshalBinary(data []byte) error {
+	if len(data) != 2 {
+		return fmt.Errorf("expected 2 bytes, got %d", len(data))
+	}
+	m.X, m.Y = data[0], data[1]
+	return nil
+}
+
+func TestBinaryCodec(t *testing.T) {
+	registry := MessageRegistry[Message]{}
+	registry.Register(&testPointMessage{}, &testChatMessage{})
+	codec := NewBinaryCodec(registry)
+
+	frame, err := codec.Encode(&testPointMessage{X: 1, Y: 2})
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if string(frame) != "point \x01\x02" {
+		t.Errorf("unexpected frame %q", frame)
+	}
+	msg, err := codec.Decode(frame)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if point, ok := msg.(*testPointMessage); !ok || *point != (testPointMessage{X: 1, Y: 2}) {
+		t.Errorf("expected point 1,2, got %#v", msg)
+	}
+	if _, err := codec.Encode(&testChatMessage{}); err == nil {
+		t.Error("expected error encoding a message without MarshalBinary")
+	}
+	if _, err := codec.Decode([]byte("point \x01")); err == nil {
+		t.Error("expected error decoding a short payload")
+	}
+}
+
+// testVectorMessage stands in for a protobuf-generated type, encoding its
+// fields as varints like the protobuf wire format does.
+type testVectorMessage struct {
+	X, Y uint64
+}
+
+func (m *testVectorMessage) Type() string {
+	return "vector"
+}
+
+func (m *testVectorMessage) Marshal() ([]byte, error) {
+	return binary.AppendUvarint(binary.AppendUvarint(nil, m.X), m.Y), nil
+}
+
+func (m *testVectorMessage) Unmarshal(data []byte) error {
+	x, n := binary.Uvarint(data)
+	if n <= 0 {
+		return errors.New("invalid x")
+	}
+	y, k := binary.Uvarint(data[n:])
+	if k <= 0 || n+k != len(data) {
+		return errors.New("invalid y")
+	}
+	m.X, m.Y = x, y
+	return nil
+}
+
+func TestProtoCodec(t *testing.T) {
+	registry := MessageRegistry[ProtoMessage]{}
+	registry.Register(&testVectorMessage{})
+	codec := NewProtoCodec(registry)
+
+	frame, err := codec.Encode(&testVectorMessage{X: 1, Y: 300})
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if string(frame) != "vector \x01\xac\x02" {
+		t.Errorf("unexpected frame %q", frame)
+	}
+	msg, err := codec.Decode(frame)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if vector, ok := msg.(*testVectorMessage); !ok || *vector != (testVectorMessage{X: 1, Y: 300}) {
+		t.Errorf("expected vector 1,300, got %#v", msg)
+	}
+	if _, err := codec.Decode([]byte("vector \x01")); err == nil {
+		t.Error("expected error decoding a truncated payload")
+	}
+	if _, err := codec.Decode([]byte("point \x01\x02")); err == nil {
+		t.Error("expected error decoding an unregistered type")
+	}
+}
+
+type testCodecRoomMetadata struct {
+	codec Codec[Message]
+}
+
+func (m *testCodecRoomMetadata) RoomCodec() Codec[Message] {
+	return m.codec
+}
+
+func TestRoomCodecSelector(t *testing.T) {
+	registry := MessageRegistry[Message]{}
+	registry.Register(&testPointMessage{})
+	hotelCodec := NewJSONCodec(registry)
+	binaryCodec := NewBinaryCodec(registry)
+	h := New(func(ctx context.Context, id string) (*testCodecRoomMetadata, error) {
+		if id == "binary" {
+			return &testCodecRoomMetadata{codec: binaryCodec}, nil
+		}
+		return &testCodecRoomMetadata{}, nil
+	}, func(ctx context.Context, room *Room[testCodecRoomMetadata, testClientMetadata, Message]) {
+		for range room.Events() {
+		}
+	}, WithCodec(hotelCodec))
+	defer h.Shutdown(context.Background())
+
+	tests := []struct {
+		id    string
+		codec Codec[Message]
+		frame string
+	}{
+		{"binary", binaryCodec, "point \x01\x02"},
+		{"default", hotelCodec, `point {"X":1,"Y":2}`},
+	}
+	for _, test := range tests {
+		room, err := h.GetOrCreateRoom(test.id)
+		if err != nil {
+			t.Fatalf("GetOrCreateRoom failed: %v", err)
+		}
+		if room.Codec() != test.codec {
+			t.Errorf("room %s: expected codec %T, got %T", test.id, test.codec, room.Codec())
+		}
+		client, err := room.NewClient(&testClientMetadata{Name: "alice"})
+		if err != nil {
+			t.Fatalf("NewClient failed: %v", err)
+		}
+		if err := room.SendToClient(client, &testPointMessage{X: 1, Y: 2}); err != nil {
+			t.Fatalf("SendToClient failed: %v", err)
+		}
+		if got := receiveNext(t, client.ReceiveBytes()); string(got) != test.frame {
+			t.Errorf("room %s: expected frame %q, got %q", test.id, test.frame, got)
+		}
+	}
+}
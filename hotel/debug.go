@@ -0,0 +1,50 @@
+package hotel
+
+import "time"
+
+// ClientDebugInfo is a snapshot of a client's state, e.g. for an admin
+// endpoint answering why a client did or didn't get some data.
+type ClientDebugInfo[ClientMetadata any] struct {
+	ID       string
+	Metadata *ClientMetadata
+	// Rooms are the IDs of the rooms the client is in, in join order.
+	Rooms []string
+	// Queued is the number of items waiting to be received by the consumer.
+	Queued int
+	// Sent counts the items handed to the consumer, Received the data the
+	// client sent to its rooms, and Dropped the items dropped because the
+	// client's buffer was full.
+	Sent         uint64
+	Received     uint64
+	Dropped      uint64
+	Drained      bool
+	ConnectedAt  time.Time
+	LastActivity time.Time
+	// CloseReason is only set if Closed is true.
+	Closed      bool
+	CloseReason LeaveReason
+}
+
+// Debug returns a snapshot of the client's state. It's safe to call at any
+// time, but the fields are read one at a time, so they may be slightly out of
+// sync with each other if the client is busy.
+func (c *Client[ClientMetadata, DataType]) Debug() ClientDebugInfo[ClientMetadata] {
+	info := ClientDebugInfo[ClientMetadata]{
+		ID:           c.id,
+		Metadata:     c.Metadata(),
+		Queued:       int(c.pending.Load()),
+		Sent:         c.sent.Load(),
+		Received:     c.received.Load(),
+		Dropped:      c.drops.Load(),
+		ConnectedAt:  c.connectedAt,
+		LastActivity: c.LastActivity(),
+	}
+	for _, room := range c.roomList() {
+		info.Rooms = append(info.Rooms, room.ID())
+	}
+	c.bufferMu.RLock()
+	info.Drained = c.drained
+	c.bufferMu.RUnlock()
+	info.CloseReason, info.Closed = c.CloseReason()
+	return info
+}
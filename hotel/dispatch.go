@@ -0,0 +1,87 @@
+package hotel
+
+import "reflect"
+
+// Dispatcher routes the data of EventCustom events to handlers registered by
+// the data's type with On, so that a handler for a DataType like
+// Message doesn't need one big type switch. It must be set up before it's
+// used, and is then safe to use from any goroutine.
+type Dispatcher[ClientMetadata, DataType any] struct {
+	handlers map[reflect.Type]func(client *Client[ClientMetadata, DataType], data DataType)
+	// Handlers for interface types, in registration order. They're tried when
+	// no handler is registered for the data's concrete type.
+	ifaceHandlers []ifaceHandler[ClientMetadata, DataType]
+	fallback      func(client *Client[ClientMetadata, DataType], data DataType)
+}
+
+func NewDispatcher[ClientMetadata, DataType any]() *Dispatcher[ClientMetadata, DataType] {
+	return &Dispatcher[ClientMetadata, DataType]{
+		handlers: make(map[reflect.Type]func(client *Client[ClientMetadata, DataType], data DataType)),
+	}
+}
+
+// On registers a handler for data of type T, e.g. *ChatMessage, replacing any
+// previous handler for T. T may also be an interface type, in which case the
+// handler gets any data implementing it that has no handler for its concrete
+// type, with the interface handler registered first taking precedence. It's a
+// function rather than a method because methods can't have type parameters.
+func On[T, ClientMetadata, DataType any](d *Dispatcher[ClientMetadata, DataType], handler func(client *Client[ClientMetadata, DataType], msg T)) {
+	t := reflect.TypeFor[T]()
+	if t.Kind() != reflect.Interface {
+		d.handlers[t] = func(client *Client[ClientMetadata, DataType], data DataType) {
+			handler(client, any(data).(T))
+		}
+		return
+	}
+	h := ifaceHandler[ClientMetadata, DataType]{
+		iface: t,
+		handle: func(client *Client[ClientMetadata, DataType], data DataType) bool {
+			msg, ok := any(data).(T)
+			if ok {
+				handler(client, msg)
+			}
+			return ok
+		},
+	}
+	for i := range d.ifaceHandlers {
+		if d.ifaceHandlers[i].iface == t {
+			d.ifaceHandlers[i] = h
+			return
+		}
+	}
+	d.ifaceHandlers = append(d.ifaceHandlers, h)
+}
+
+type ifaceHandler[ClientMetadata, DataType any] struct {
+	iface reflect.Type
+	// handle calls the handler and returns true if data implements iface.
+	handle func(client *Client[ClientMetadata, DataType], data DataType) bool
+}
+
+// Default sets a handler for data that no handler was registered for.
+func (d *Dispatcher[ClientMetadata, DataType]) Default(handler func(client *Client[ClientMetadata, DataType], data DataType)) {
+	d.fallback = handler
+}
+
+// Dispatch calls the handler registered for the type of the event's data and
+// returns true, or returns false if the event isn't an EventCustom or there's
+// no handler for it (not even a default one).
+func (d *Dispatcher[ClientMetadata, DataType]) Dispatch(event Event[ClientMetadata, DataType]) bool {
+	if event.Type != EventCustom {
+		return false
+	}
+	if handler, ok := d.handlers[reflect.TypeOf(any(event.Data))]; ok {
+		handler(event.Client, event.Data)
+		return true
+	}
+	for _, h := range d.ifaceHandlers {
+		if h.handle(event.Client, event.Data) {
+			return true
+		}
+	}
+	if d.fallback != nil {
+		d.fallback(event.Client, event.Data)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,19 @@
+package hotel
+
+import "errors"
+
+var (
+	ErrCreationRateLimited  = errors.New("room creation rate limit exceeded")
+	ErrRoomClosed           = errors.New("room is closed")
+	ErrClientDrained        = errors.New("client has been drained")
+	ErrTooManyWaiters       = errors.New("too many callers waiting for room init")
+	ErrDataDropped          = errors.New("send channel full, data dropped")
+	ErrMessageTooLarge      = errors.New("message exceeds maximum size")
+	ErrBroadcastRateLimited = errors.New("broadcast rate limit exceeded")
+	ErrAdmissionDenied      = errors.New("client was not admitted to the room")
+	ErrHotelShuttingDown    = errors.New("hotel is shutting down")
+	ErrNilMetadata          = errors.New("metadata is nil")
+	ErrRoomFull             = errors.New("room is full")
+	ErrClientRateLimited    = errors.New("client data rate limit exceeded")
+	ErrBufferFull           = errors.New("send channel stayed full, client evicted")
+)
@@ -1,6 +1,9 @@
 package hotel
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 type EventType int
 
@@ -12,6 +15,38 @@ func (et EventType) String() string {
 		return "EventLeave"
 	case EventCustom:
 		return "EventCustom"
+	case EventHandlerRestarted:
+		return "EventHandlerRestarted"
+	case EventDecodeError:
+		return "EventDecodeError"
+	case EventMetadataChanged:
+		return "EventMetadataChanged"
+	case EventEmpty:
+		return "EventEmpty"
+	case EventOccupied:
+		return "EventOccupied"
+	case EventReinitialized:
+		return "EventReinitialized"
+	case EventRoomCreated:
+		return "EventRoomCreated"
+	case EventRoomClosed:
+		return "EventRoomClosed"
+	case EventRoomOccupancyChanged:
+		return "EventRoomOccupancyChanged"
+	case EventTick:
+		return "EventTick"
+	case EventUserJoined:
+		return "EventUserJoined"
+	case EventUserLeft:
+		return "EventUserLeft"
+	case EventClientRemoved:
+		return "EventClientRemoved"
+	case EventPresence:
+		return "EventPresence"
+	case EventRoomMetadataChanged:
+		return "EventRoomMetadataChanged"
+	case EventDropped:
+		return "EventDropped"
 	}
 	return fmt.Sprintf("<!EventType %d>", et)
 }
@@ -20,10 +55,115 @@ const (
 	EventJoin EventType = iota
 	EventLeave
 	EventCustom
+	// EventHandlerRestarted is emitted when the handler was restarted
+	// following a panic, so that it can re-sync its state. The restarted
+	// handler first receives the events that were already queued for the
+	// crashed one, then this.
+	EventHandlerRestarted
+	// EventDecodeError is emitted when inbound bytes from a client couldn't
+	// be decoded. The event carries the raw bytes and the decode error.
+	EventDecodeError
+	// EventMetadataChanged is emitted when a client's metadata was replaced
+	// with Client.SwapMetadata.
+	EventMetadataChanged
+	// EventEmpty is emitted when the last client leaves the room, and
+	// EventOccupied when a client joins an empty room (just before its
+	// EventJoin). Neither carries a client.
+	EventEmpty
+	EventOccupied
+	// EventReinitialized is emitted when Room.Reinitialize replaced the
+	// room's metadata.
+	EventReinitialized
+	// EventRoomCreated, EventRoomClosed and EventRoomOccupancyChanged are only
+	// emitted in the hotel's lobby. They carry a snapshot of the room they're
+	// about, but no client.
+	EventRoomCreated
+	EventRoomClosed
+	EventRoomOccupancyChanged
+	// EventTick is emitted periodically by the tickers started with
+	// Room.StartTicker.
+	EventTick
+	// EventUserJoined is emitted after the EventJoin of a user's first client
+	// in the room, and EventUserLeft after the EventLeave of its last one
+	// (see Room.NewClientForUser). Both carry that client.
+	EventUserJoined
+	EventUserLeft
+	// EventClientRemoved is emitted after a client was removed from the room
+	// because sending data to it failed, following its EventLeave. It carries
+	// the client and the send error.
+	EventClientRemoved
+	// EventPresence is emitted when a client changed its presence with
+	// Client.SetPresence. It carries the client and both presences.
+	EventPresence
+	// EventRoomMetadataChanged is emitted when the room's metadata was
+	// changed with Room.UpdateMetadata. It carries no client.
+	EventRoomMetadataChanged
+	// EventDropped is emitted once the handler catches up after events were
+	// dropped because it fell behind (see EventOverflowDrop). It carries how
+	// many events were dropped since the previous EventDropped, but no
+	// client.
+	EventDropped
+)
+
+// EventOverflowPolicy decides what happens when a room emits an event while
+// its handler is too far behind for the event to fit in the events channel.
+type EventOverflowPolicy int
+
+const (
+	// EventOverflowClose closes the room, disconnecting all its clients.
+	EventOverflowClose EventOverflowPolicy = iota
+	// EventOverflowDrop drops the event, and tells the handler how many
+	// events it missed with an EventDropped once there's room again.
+	EventOverflowDrop
+	// EventOverflowBlock makes the emitter wait until the handler reads, the
+	// room closes, or a second has passed (as measured by the hotel's
+	// Clock). Events that time out are dropped as with EventOverflowDrop,
+	// so a handler that emits events itself (e.g. through
+	// Room.UpdateMetadata) stalls rather than waiting for itself forever.
+	EventOverflowBlock
 )
 
+// eventBlockTimeout is how long EventOverflowBlock waits for room in the
+// events channel.
+const eventBlockTimeout = time.Second
+
+func (p EventOverflowPolicy) String() string {
+	switch p {
+	case EventOverflowClose:
+		return "EventOverflowClose"
+	case EventOverflowDrop:
+		return "EventOverflowDrop"
+	case EventOverflowBlock:
+		return "EventOverflowBlock"
+	}
+	return fmt.Sprintf("<!EventOverflowPolicy %d>", p)
+}
+
+// NoData can be used as the DataType of rooms that only care about presence
+// (joins and leaves) and never send data. It takes up no memory, so buffering
+// and events cost nothing extra.
+type NoData = struct{}
+
 type Event[ClientMetadata, DataType any] struct {
 	Type   EventType
 	Client *Client[ClientMetadata, DataType]
 	Data   DataType
+	// Raw is only set for EventDecodeError, and Err for EventDecodeError and
+	// EventClientRemoved.
+	Raw []byte
+	Err error
+	// Reason is only set for EventLeave and EventUserLeft.
+	Reason LeaveReason
+	// RoomInfo is only set for the lobby's room lifecycle events.
+	RoomInfo *RoomInfo
+	// Ticker is the name of the ticker that emitted an EventTick.
+	Ticker string
+	// Presence and PrevPresence are only set for EventPresence.
+	Presence     Presence
+	PrevPresence Presence
+	// Dropped is only set for EventDropped.
+	Dropped uint64
+	// Emitted is when the event was emitted, so time.Since(event.Emitted)
+	// is how long it waited before the handler read it.
+	Emitted time.Time
 }
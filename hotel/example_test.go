@@ -0,0 +1,38 @@
+package hotel_test
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/blixt/go-hotel/hotel"
+)
+
+type player struct {
+	Team string
+}
+
+// A presence-only room that tracks who's in it without sending any data.
+func ExampleNoData() {
+	h := hotel.New(func(ctx context.Context, id string) (*struct{}, error) {
+		return &struct{}{}, nil
+	}, func(ctx context.Context, room *hotel.Room[struct{}, player, hotel.NoData]) {
+		for event := range room.Events() {
+			// Only EventJoin and EventLeave matter here.
+			_ = event
+		}
+	})
+	room, err := h.GetOrCreateRoom("lobby")
+	if err != nil {
+		panic(err)
+	}
+	defer room.Close()
+
+	for _, team := range []string{"red", "blue", "red"} {
+		if _, err := room.NewClient(&player{Team: team}); err != nil {
+			panic(err)
+		}
+	}
+	counts := room.CountBy(func(p *player) string { return p.Team })
+	fmt.Println("red:", counts["red"], "blue:", counts["blue"])
+	// Output: red: 2 blue: 1
+}
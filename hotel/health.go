@@ -0,0 +1,87 @@
+package hotel
+
+import (
+	"fmt"
+	"time"
+)
+
+// staleAfter is how long a closed room or client may stay listed before
+// HealthCheck reports it, since removal happens shortly after closing.
+const staleAfter = time.Second
+
+// HealthCheck validates the room's internal consistency and returns an error
+// describing the first problem found. It only reads state, so it's safe to
+// call periodically, e.g. from a monitoring endpoint.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) HealthCheck() error {
+	r.mu.RLock()
+	clients := r.clients
+	clientList := r.clientList
+	r.mu.RUnlock()
+
+	if r.closed.Load() {
+		// Closing marks the room closed before it clears the clients, so
+		// they're only a problem if they outstay that.
+		if closedAt := r.closedAt.Load(); len(clients) > 0 && closedAt != 0 && time.Since(time.Unix(0, closedAt)) > staleAfter {
+			return fmt.Errorf("room %s is closed but has %d clients", r.ID(), len(clients))
+		}
+		return nil
+	}
+	for client := range clients {
+		if closedAt := client.closedAt.Load(); closedAt != 0 && time.Since(time.Unix(0, closedAt)) > staleAfter {
+			return fmt.Errorf("room %s lists closed client %s", r.ID(), client.ID())
+		}
+		if !client.inRoom(r) {
+			return fmt.Errorf("room %s lists client %s that doesn't know it's in the room", r.ID(), client.ID())
+		}
+	}
+	if clientList != nil {
+		if len(clientList) != len(clients) {
+			return fmt.Errorf("room %s has a stale client snapshot (%d clients, snapshot has %d)", r.ID(), len(clients), len(clientList))
+		}
+		for _, client := range clientList {
+			if _, ok := clients[client]; !ok {
+				return fmt.Errorf("room %s has a stale client snapshot (client %s is not in the room)", r.ID(), client.ID())
+			}
+		}
+	}
+	// A backlog this large means the handler is stuck or too slow, and the
+	// room is about to close itself.
+	if backlog := len(r.eventsCh); backlog > cap(r.eventsCh)*9/10 {
+		return fmt.Errorf("room %s has %d of %d events waiting for the handler", r.ID(), backlog, cap(r.eventsCh))
+	}
+	return nil
+}
+
+// HealthCheck validates that every room in the hotel is stored where it
+// should be and is open, and runs each room's HealthCheck. It returns an error
+// describing the first problem found.
+func (h *Hotel[RoomMetadata, ClientMetadata, DataType]) HealthCheck() error {
+	var err error
+	h.store.Range(func(id string, room *Room[RoomMetadata, ClientMetadata, DataType]) bool {
+		// Renames update the room and the store under the same lock, so the
+		// two can only disagree while a rename is in progress.
+		lock := h.lock(id)
+		lock.Lock()
+		stored, ok := h.store.Get(id)
+		if ok && stored == room && room.ID() != id {
+			err = fmt.Errorf("room %s is stored as %s", room.ID(), id)
+		}
+		lock.Unlock()
+		if closedAt := room.closedAt.Load(); closedAt != 0 && time.Since(time.Unix(0, closedAt)) > staleAfter {
+			err = fmt.Errorf("room %s was closed but is still in the hotel", id)
+		}
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, room := range h.allRooms() {
+		if err := room.HealthCheck(); err != nil {
+			return err
+		}
+	}
+	if lobby := h.Lobby(); lobby != nil {
+		return lobby.HealthCheck()
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package hotel
+
+import "sync"
+
+// history is a bounded ring buffer of the most recently broadcast data. Each
+// entry is numbered with a sequence number, starting at 1.
+type history[DataType any] struct {
+	mu      sync.Mutex
+	items   []DataType
+	start   int
+	lastSeq uint64
+}
+
+func newHistory[DataType any](size int) *history[DataType] {
+	return &history[DataType]{items: make([]DataType, 0, size)}
+}
+
+func (h *history[DataType]) add(data DataType) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastSeq++
+	if len(h.items) < cap(h.items) {
+		h.items = append(h.items, data)
+		return
+	}
+	h.items[h.start] = data
+	h.start = (h.start + 1) % len(h.items)
+}
+
+// since returns the entries after seq, oldest first, along with the sequence
+// number of the last entry.
+func (h *history[DataType]) since(seq uint64) ([]DataType, uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.sinceLocked(seq), h.lastSeq
+}
+
+func (h *history[DataType]) sinceLocked(seq uint64) []DataType {
+	n := len(h.items)
+	if seq >= h.lastSeq {
+		n = 0
+	} else if h.lastSeq-seq < uint64(n) {
+		n = int(h.lastSeq - seq)
+	}
+	out := make([]DataType, n)
+	for i := range n {
+		out[i] = h.items[(h.start+len(h.items)-n+i)%len(h.items)]
+	}
+	return out
+}
+
+// resize changes how many entries the history keeps, keeping as many of the
+// most recent entries as fit along with their sequence numbers. It happens
+// under the same lock as add, so no entry added concurrently is lost.
+func (h *history[DataType]) resize(size int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	items := h.sinceLocked(0)
+	if len(items) > size {
+		items = items[len(items)-size:]
+	}
+	h.items = append(make([]DataType, 0, size), items...)
+	h.start = 0
+}
@@ -0,0 +1,32 @@
+package hotel
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	h := newHistory[int](3)
+	if got, seq := h.since(0); len(got) != 0 || seq != 0 {
+		t.Fatalf("expected empty history, got %v (seq %d)", got, seq)
+	}
+	for i := 1; i <= 5; i++ {
+		h.add(i)
+	}
+	tests := []struct {
+		seq  uint64
+		want []int
+	}{
+		{0, []int{3, 4, 5}},
+		{3, []int{4, 5}},
+		{4, []int{5}},
+		{5, []int{}},
+		{9, []int{}},
+	}
+	for _, test := range tests {
+		got, seq := h.since(test.seq)
+		if !slices.Equal(got, test.want) || seq != 5 {
+			t.Errorf("since(%d) = %v (seq %d), want %v (seq 5)", test.seq, got, seq, test.want)
+		}
+	}
+}
@@ -1,72 +1,374 @@
 package hotel
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"hash/maphash"
+	"log/slog"
+	"slices"
 	"sync"
+	"sync/atomic"
 )
 
 type Hotel[RoomMetadata, ClientMetadata, DataType any] struct {
-	mu      sync.RWMutex
-	rooms   map[string]*Room[RoomMetadata, ClientMetadata, DataType]
+	store RoomStore[RoomMetadata, ClientMetadata, DataType]
+
+	// locks serialize changes to the rooms in the store, with each lock
+	// covering the room IDs that hash to it.
+	locks   []sync.Mutex
+	seed    maphash.Seed
 	init    RoomInitFunc[RoomMetadata]
 	handler RoomHandlerFunc[RoomMetadata, ClientMetadata, DataType]
+	codec   Codec[DataType]
+	logger  *slog.Logger
+	clock   Clock
+	metrics MetricsObserver // The observer, if it implements MetricsObserver.
+	opts    options
+
+	creationLimiter *keyedLimiter
+	stats           hotelStats
+	shuttingDown    atomic.Bool
+
+	lobbyMu sync.RWMutex
+	lobby   *Room[RoomMetadata, ClientMetadata, DataType]
 }
 
-func New[RoomMetadata, ClientMetadata, DataType any](init RoomInitFunc[RoomMetadata], handler RoomHandlerFunc[RoomMetadata, ClientMetadata, DataType]) *Hotel[RoomMetadata, ClientMetadata, DataType] {
-	return &Hotel[RoomMetadata, ClientMetadata, DataType]{
-		rooms:   make(map[string]*Room[RoomMetadata, ClientMetadata, DataType]),
+// New creates a hotel whose rooms are set up by init and run by handler. It
+// panics if an option doesn't fit the hotel's types, e.g. a codec for another
+// DataType or a RoomStore for rooms of other types.
+func New[RoomMetadata, ClientMetadata, DataType any](init RoomInitFunc[RoomMetadata], handler RoomHandlerFunc[RoomMetadata, ClientMetadata, DataType], opts ...Option) *Hotel[RoomMetadata, ClientMetadata, DataType] {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	h := &Hotel[RoomMetadata, ClientMetadata, DataType]{
+		locks:   make([]sync.Mutex, max(1, o.shards)),
+		seed:    maphash.MakeSeed(),
 		init:    init,
 		handler: handler,
+		opts:    o,
+	}
+	if o.store != nil {
+		store, ok := o.store.(RoomStore[RoomMetadata, ClientMetadata, DataType])
+		if !ok {
+			panic(fmt.Sprintf("room store %T does not store %T", o.store, (*Room[RoomMetadata, ClientMetadata, DataType])(nil)))
+		}
+		h.store = store
+	} else {
+		h.store = newMemoryStore[RoomMetadata, ClientMetadata, DataType](o.shards)
+	}
+	if o.codec != nil {
+		codec, ok := o.codec.(Codec[DataType])
+		if !ok {
+			panic(fmt.Sprintf("codec %T does not encode %T", o.codec, *new(DataType)))
+		}
+		h.codec = codec
+	}
+	switch {
+	case o.slog != nil:
+		h.logger = o.slog
+	case o.logger != nil:
+		h.logger = slog.New(&loggerHandler{logger: o.logger})
+	default:
+		h.logger = slog.New(&loggerHandler{logger: stdLogger{}})
+	}
+	h.clock = o.clock
+	if h.clock == nil {
+		h.clock = realClock{}
+	}
+	h.metrics, _ = o.observer.(MetricsObserver)
+	if o.creationRate > 0 {
+		h.creationLimiter = newKeyedLimiter(o.creationRate, o.creationBurst)
 	}
+	return h
 }
 
 func (h *Hotel[RoomMetadata, ClientMetadata, DataType]) GetOrCreateRoom(id string) (*Room[RoomMetadata, ClientMetadata, DataType], error) {
+	return h.getOrCreateRoom(context.Background(), "", id)
+}
+
+// GetOrCreateRoomContext is like GetOrCreateRoom, but stops waiting for the
+// room's init to finish when ctx is done. The room's init keeps running, so
+// the room can still be created for later callers.
+func (h *Hotel[RoomMetadata, ClientMetadata, DataType]) GetOrCreateRoomContext(ctx context.Context, id string) (*Room[RoomMetadata, ClientMetadata, DataType], error) {
+	return h.getOrCreateRoom(ctx, "", id)
+}
+
+// GetOrCreateRoomFrom is like GetOrCreateRoom, but attributes room creation to
+// sourceID (e.g. a user or IP address). If the hotel has a creation rate limit
+// and the source creates rooms too quickly, ErrCreationRateLimited is
+// returned. Getting rooms that already exist, or creating them with an empty
+// sourceID, is never limited.
+func (h *Hotel[RoomMetadata, ClientMetadata, DataType]) GetOrCreateRoomFrom(sourceID, id string) (*Room[RoomMetadata, ClientMetadata, DataType], error) {
+	return h.getOrCreateRoom(context.Background(), sourceID, id)
+}
+
+func (h *Hotel[RoomMetadata, ClientMetadata, DataType]) getOrCreateRoom(ctx context.Context, sourceID, id string) (*Room[RoomMetadata, ClientMetadata, DataType], error) {
 	if id == "" {
 		return nil, errors.New("invalid room id: cannot be empty")
 	}
+	if h.shuttingDown.Load() && !h.opts.lookupsDuringShutdown {
+		return nil, ErrHotelShuttingDown
+	}
 
-	// If a room exists we only need a read lock to retrieve it.
-	h.mu.RLock()
-	room, exists := h.rooms[id]
-	h.mu.RUnlock()
+	// If a room exists we don't need to lock anything to retrieve it.
+	room, exists := h.store.Get(id)
 
 	if !exists {
-		// A room might've been created in the short duration between RUnlock()
-		// and this code so now we need a write lock where we only create the
-		// room if it still doesn't exist.
-		h.mu.Lock()
-		room, exists = h.rooms[id]
+		// A room might've been created in the short duration between Get()
+		// and this code so now we need a lock where we only create the room
+		// if it still doesn't exist.
+		lock := h.lock(id)
+		lock.Lock()
+		room, exists = h.store.Get(id)
 		if !exists {
-			room = newRoom(id, h.init, h.handler)
-			h.rooms[id] = room
+			if h.shuttingDown.Load() {
+				lock.Unlock()
+				return nil, ErrHotelShuttingDown
+			}
+			if h.creationLimiter != nil && sourceID != "" && !h.creationLimiter.allow(sourceID) {
+				lock.Unlock()
+				return nil, ErrCreationRateLimited
+			}
+			room = newRoom(h, id, h.init, h.handler)
+			h.store.Put(id, room)
+			// Book keeping for the room's lifetime happens separately from
+			// this call, since this call might stop waiting for init early.
+			go h.trackRoom(room)
 		}
-		h.mu.Unlock()
+		lock.Unlock()
 	}
 
 	// Wait for room init to run (or it might've already run in which case this
 	// will immediately return nil).
-	err := room.initGroup.Wait()
+	if err := room.waitInit(ctx, h.opts.maxWaiters); err != nil {
+		return nil, err
+	}
 
+	return room, nil
+}
+
+// trackRoom removes the room from the hotel if its init fails or once it
+// closes.
+func (h *Hotel[RoomMetadata, ClientMetadata, DataType]) trackRoom(room *Room[RoomMetadata, ClientMetadata, DataType]) {
+	<-room.initDone
+	if room.initErr != nil {
+		h.removeRoom(room)
+		return
+	}
+	h.notifyLobby(EventRoomCreated, room)
+	if h.metrics != nil {
+		h.metrics.RoomCreated(room.ID())
+	}
+	<-room.ctx.Done()
+	h.removeRoom(room)
+	h.notifyLobby(EventRoomClosed, room)
+	if h.metrics != nil {
+		h.metrics.RoomClosed(room.ID())
+	}
+}
+
+// RenameRoom moves a live room from oldID to newID without disrupting its
+// clients or handler. It fails if no room exists at oldID or if newID is
+// already taken.
+func (h *Hotel[RoomMetadata, ClientMetadata, DataType]) RenameRoom(oldID, newID string) error {
+	if newID == "" {
+		return errors.New("invalid room id: cannot be empty")
+	}
+
+	// Always take locks in the same order to avoid deadlocks between
+	// concurrent renames.
+	first, second := h.lockIndex(oldID), h.lockIndex(newID)
+	if second < first {
+		first, second = second, first
+	}
+	h.locks[first].Lock()
+	defer h.locks[first].Unlock()
+	if second != first {
+		h.locks[second].Lock()
+		defer h.locks[second].Unlock()
+	}
+
+	room, exists := h.store.Get(oldID)
 	if !exists {
-		// This was the call that created the room, so do additional book
-		// keeping once its init has finished and we know if it errored.
-		if err != nil {
-			h.mu.Lock()
-			delete(h.rooms, id)
-			h.mu.Unlock()
-		} else {
-			go func() {
-				<-room.ctx.Done()
-				h.mu.Lock()
-				delete(h.rooms, room.id)
-				h.mu.Unlock()
-			}()
+		return fmt.Errorf("room %q not found", oldID)
+	}
+	if oldID == newID {
+		return nil
+	}
+	if _, taken := h.store.Get(newID); taken {
+		return fmt.Errorf("room %q already exists", newID)
+	}
+	// The room's id is updated while holding the locks so that cleanup, which
+	// also takes the lock, always sees the key the room is stored under.
+	room.setID(newID)
+	h.store.Delete(oldID)
+	h.store.Put(newID, room)
+	return nil
+}
+
+// GetRoom returns the live room with the given id. Unlike GetOrCreateRoom it
+// never creates a room (so init never runs), and it doesn't return rooms that
+// are still running their init.
+func (h *Hotel[RoomMetadata, ClientMetadata, DataType]) GetRoom(id string) (*Room[RoomMetadata, ClientMetadata, DataType], bool) {
+	room, ok := h.store.Get(id)
+	if !ok || !room.live() {
+		return nil, false
+	}
+	return room, true
+}
+
+// Room is like GetRoom, but returns nil if there is no live room with the id.
+func (h *Hotel[RoomMetadata, ClientMetadata, DataType]) Room(id string) *Room[RoomMetadata, ClientMetadata, DataType] {
+	room, _ := h.GetRoom(id)
+	return room
+}
+
+// RoomIDs returns the sorted IDs of all the live rooms in the hotel.
+func (h *Hotel[RoomMetadata, ClientMetadata, DataType]) RoomIDs() []string {
+	var ids []string
+	for _, room := range h.allRooms() {
+		if room.live() {
+			ids = append(ids, room.ID())
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// RoomCount returns the number of live rooms in the hotel.
+func (h *Hotel[RoomMetadata, ClientMetadata, DataType]) RoomCount() int {
+	count := 0
+	for _, room := range h.allRooms() {
+		if room.live() {
+			count++
 		}
 	}
+	return count
+}
+
+// CloseIdleRooms closes every room that has been empty for at least the
+// duration set with WithMinIdleDuration, without waiting for its auto-close
+// timer, and returns how many rooms it closed.
+func (h *Hotel[RoomMetadata, ClientMetadata, DataType]) CloseIdleRooms() int {
+	closed := 0
+	for _, room := range h.allRooms() {
+		if room.closeIfIdle(h.opts.minIdle) {
+			closed++
+		}
+	}
+	return closed
+}
 
+// Shutdown gives every client in every room (including the lobby) until ctx
+// is done to receive everything still buffered for them, and then closes all
+// the clients (with ReasonShutdown) and rooms and waits for the room handlers
+// to exit. Everything is closed even if ctx is done first, in which case
+// ctx's error is returned without waiting for the handlers. From the start of
+// the shutdown, GetOrCreateRoom fails with ErrHotelShuttingDown (see
+// WithLookupsDuringShutdown).
+func (h *Hotel[RoomMetadata, ClientMetadata, DataType]) Shutdown(ctx context.Context) error {
+	return h.shutdown(ctx, nil)
+}
+
+// ShutdownWithFarewell is like Shutdown, but first sends farewell to every
+// client, e.g. to tell them to reconnect elsewhere. The farewell is not part
+// of any room's history.
+func (h *Hotel[RoomMetadata, ClientMetadata, DataType]) ShutdownWithFarewell(ctx context.Context, farewell DataType) error {
+	return h.shutdown(ctx, &farewell)
+}
+
+func (h *Hotel[RoomMetadata, ClientMetadata, DataType]) shutdown(ctx context.Context, farewell *DataType) error {
+	h.shuttingDown.Store(true)
+	// Creators check the flag under their ID's lock, so once every lock has
+	// been taken, any room created before the flag was set is in the store.
+	for i := range h.locks {
+		h.locks[i].Lock()
+		h.locks[i].Unlock()
+	}
+	rooms := h.allRooms()
+	if lobby := h.Lobby(); lobby != nil {
+		rooms = append(rooms, lobby)
+	}
+	var clients []*Client[ClientMetadata, DataType]
+	for _, room := range rooms {
+		if farewell != nil {
+			room.broadcast(nil, queuedItem[DataType]{data: *farewell})
+		}
+		clients = append(clients, room.Clients()...)
+	}
+
+	err := flushClients(ctx, clients)
+	for _, client := range clients {
+		client.CloseWithReason(ReasonShutdown)
+	}
+	for _, room := range rooms {
+		room.Close()
+	}
 	if err != nil {
-		return nil, err
+		return err
+	}
+	for _, room := range rooms {
+		select {
+		case <-room.handlerDone:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// flushClients waits until every client's buffer is empty (or the client is
+// closed), or until ctx is done.
+func flushClients[ClientMetadata, DataType any](ctx context.Context, clients []*Client[ClientMetadata, DataType]) error {
+	for _, client := range clients {
+		if err := client.waitFlushed(ctx); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	return room, nil
+// removeRoom deletes the room from the map under its current id, unless the
+// id has since been taken over by a different room.
+func (h *Hotel[RoomMetadata, ClientMetadata, DataType]) removeRoom(room *Room[RoomMetadata, ClientMetadata, DataType]) {
+	for {
+		id := room.ID()
+		lock := h.lock(id)
+		lock.Lock()
+		// The room may have been renamed (possibly under another lock) before
+		// the lock was acquired, in which case we try again.
+		if room.ID() != id {
+			lock.Unlock()
+			continue
+		}
+		if stored, ok := h.store.Get(id); ok && stored == room {
+			h.store.Delete(id)
+		}
+		lock.Unlock()
+		return
+	}
+}
+
+// allRooms returns a snapshot of every room in the hotel, so that callers can
+// work with the rooms without holding any locks.
+func (h *Hotel[RoomMetadata, ClientMetadata, DataType]) allRooms() []*Room[RoomMetadata, ClientMetadata, DataType] {
+	var rooms []*Room[RoomMetadata, ClientMetadata, DataType]
+	h.store.Range(func(id string, room *Room[RoomMetadata, ClientMetadata, DataType]) bool {
+		rooms = append(rooms, room)
+		return true
+	})
+	return rooms
+}
+
+func (h *Hotel[RoomMetadata, ClientMetadata, DataType]) lockIndex(id string) int {
+	if len(h.locks) == 1 {
+		return 0
+	}
+	return int(maphash.String(h.seed, id) % uint64(len(h.locks)))
+}
+
+func (h *Hotel[RoomMetadata, ClientMetadata, DataType]) lock(id string) *sync.Mutex {
+	return &h.locks[h.lockIndex(id)]
 }
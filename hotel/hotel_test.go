@@ -0,0 +1,805 @@
+package hotel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func BenchmarkGetOrCreateRoom(b *testing.B) {
+	const numRooms = 1024
+	ids := make([]string, numRooms)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("room%d", i)
+	}
+	// With churn, every eighth call creates a room and closes it again, so
+	// that lookups contend with the write locks taken for creation.
+	for _, churn := range []bool{false, true} {
+		for _, shards := range []int{1, 16} {
+			b.Run(fmt.Sprintf("churn=%t/shards=%d", churn, shards), func(b *testing.B) {
+				h := New(testInit, func(ctx context.Context, room *testRoom) {
+					<-ctx.Done()
+				}, WithShards(shards))
+				for _, id := range ids {
+					if _, err := h.GetOrCreateRoom(id); err != nil {
+						b.Fatalf("GetOrCreateRoom failed: %v", err)
+					}
+				}
+				var workers atomic.Int32
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					worker := workers.Add(1)
+					i := 0
+					for pb.Next() {
+						if churn && i%8 == 0 {
+							room, err := h.GetOrCreateRoom(fmt.Sprintf("churn%d-%d", worker, i))
+							if err != nil {
+								b.Fatalf("GetOrCreateRoom failed: %v", err)
+							}
+							room.Close()
+						} else if _, err := h.GetOrCreateRoom(ids[i%numRooms]); err != nil {
+							b.Fatalf("GetOrCreateRoom failed: %v", err)
+						}
+						i++
+					}
+				})
+			})
+		}
+	}
+}
+
+func TestShutdownSendsFarewell(t *testing.T) {
+	room := newTestRoom(t)
+	h := room.hotel
+	client := newTestClient(t, room, "alice")
+	received := make(chan string, 1)
+	go func() {
+		for data := range client.Receive() {
+			received <- data
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := h.ShutdownWithFarewell(ctx, "bye"); err != nil {
+		t.Fatalf("ShutdownWithFarewell failed: %v", err)
+	}
+	select {
+	case data := <-received:
+		if data != "bye" {
+			t.Errorf("expected farewell, got %q", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("client did not receive farewell")
+	}
+	if reason, closed := client.CloseReason(); !closed || reason != ReasonShutdown {
+		t.Errorf("expected client to be closed with %q after shutdown, got %q", ReasonShutdown, reason)
+	}
+}
+
+func TestShutdownWaitsForHandlers(t *testing.T) {
+	var exited atomic.Bool
+	h := New(testInit, func(ctx context.Context, room *testRoom) {
+		<-ctx.Done()
+		time.Sleep(20 * time.Millisecond)
+		exited.Store(true)
+	})
+	if _, err := h.GetOrCreateRoom("test"); err != nil {
+		t.Fatalf("GetOrCreateRoom failed: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := h.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if !exited.Load() {
+		t.Error("expected Shutdown to wait for the handler to exit")
+	}
+	if _, err := h.GetOrCreateRoom("test"); !errors.Is(err, ErrHotelShuttingDown) {
+		t.Errorf("expected ErrHotelShuttingDown after shutdown, got %v", err)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	room := newTestRoom(t)
+	h := room.hotel
+	newTestClient(t, room, "alice")
+	room.Clients()
+	if err := h.HealthCheck(); err != nil {
+		t.Fatalf("expected healthy hotel, got %v", err)
+	}
+
+	// Simulate a bug where the client snapshot wasn't invalidated.
+	room.mu.Lock()
+	room.clientList = nil
+	room.clientList = append(room.clientList, newClient[testClientMetadata, string](nil, room.clientOpts))
+	room.mu.Unlock()
+	if err := h.HealthCheck(); err == nil {
+		t.Error("expected stale client snapshot to be reported")
+	}
+}
+
+func TestHealthCheckClosingRoom(t *testing.T) {
+	room := newTestRoom(t)
+	newTestClient(t, room, "alice")
+
+	// Simulate a check racing Close, between marking the room closed and
+	// clearing its clients.
+	room.closed.Store(true)
+	room.closedAt.Store(time.Now().UnixNano())
+	if err := room.HealthCheck(); err != nil {
+		t.Errorf("expected a room that's still closing to be healthy, got %v", err)
+	}
+	room.closedAt.Store(time.Now().Add(-2 * staleAfter).UnixNano())
+	if err := room.HealthCheck(); err == nil {
+		t.Error("expected clients left in a closed room to be reported")
+	}
+	room.closed.Store(false)
+	room.closedAt.Store(0)
+}
+
+func TestStatsCountsInits(t *testing.T) {
+	h := New(func(ctx context.Context, id string) (*testRoomMetadata, error) {
+		if id == "bad" {
+			return nil, errors.New("init failed")
+		}
+		return &testRoomMetadata{}, nil
+	}, func(ctx context.Context, room *testRoom) {
+		<-ctx.Done()
+	})
+	room, err := h.GetOrCreateRoom("good")
+	if err != nil {
+		t.Fatalf("GetOrCreateRoom failed: %v", err)
+	}
+	defer room.Close()
+	if _, err := h.GetOrCreateRoom("bad"); err == nil {
+		t.Fatal("expected init error")
+	}
+
+	stats := h.Stats()
+	if stats.Inits != 2 || stats.InitErrors != 1 || stats.InitDuration.Count != 2 {
+		t.Errorf("expected 2 inits with 1 error, got %+v", stats)
+	}
+}
+
+type testRoomStore struct {
+	*memoryStore[testRoomMetadata, testClientMetadata, string]
+	puts int
+}
+
+func (s *testRoomStore) Put(id string, room *testRoom) {
+	s.puts++
+	s.memoryStore.Put(id, room)
+}
+
+func TestRoomStore(t *testing.T) {
+	store := &testRoomStore{memoryStore: newMemoryStore[testRoomMetadata, testClientMetadata, string](1)}
+	room := newTestRoom(t, WithRoomStore(store))
+	h := room.hotel
+	if again, _ := h.GetOrCreateRoom("test"); again != room {
+		t.Fatal("expected the stored room to be returned")
+	}
+	if store.puts != 1 {
+		t.Errorf("expected 1 put, got %d", store.puts)
+	}
+
+	room.Close()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, ok := store.Get("test"); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected closed room to be deleted from the store")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRoomStoreMismatch(t *testing.T) {
+	store := newMemoryStore[testRoomMetadata, testClientMetadata, []byte](1)
+	defer func() {
+		if recover() == nil {
+			t.Error("expected New to panic with a store for other types")
+		}
+	}()
+	New(testInit, func(ctx context.Context, room *testRoom) {
+		<-ctx.Done()
+	}, WithRoomStore(store))
+}
+
+func TestCodecMismatch(t *testing.T) {
+	codec := NewJSONCodec(MessageRegistry[Message]{})
+	defer func() {
+		if recover() == nil {
+			t.Error("expected New to panic with a codec for another DataType")
+		}
+	}()
+	New(testInit, func(ctx context.Context, room *testRoom) {
+		<-ctx.Done()
+	}, WithCodec(codec))
+}
+
+type testEventObserver struct {
+	processed chan EventType
+}
+
+func (o *testEventObserver) InitCompleted(roomID string, duration time.Duration, err error) {}
+
+func (o *testEventObserver) EventProcessed(roomID string, eventType EventType, queueWait, handlerDuration time.Duration) {
+	if queueWait < 0 || handlerDuration < time.Millisecond {
+		return
+	}
+	o.processed <- eventType
+}
+
+func TestTrackEvent(t *testing.T) {
+	observer := &testEventObserver{processed: make(chan EventType, 10)}
+	room := newTestRoomWithHandler(t, func(ctx context.Context, room *testRoom) {
+		for event := range room.Events() {
+			done := room.TrackEvent(event)
+			time.Sleep(time.Millisecond)
+			done()
+		}
+	}, WithObserver(observer))
+	room.Emit(Event[testClientMetadata, string]{Type: EventCustom})
+
+	select {
+	case eventType := <-observer.processed:
+		if eventType != EventCustom {
+			t.Errorf("expected EventCustom, got %s", eventType)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected event to be observed")
+	}
+}
+
+func TestGetOrCreateRoomDuringShutdown(t *testing.T) {
+	room := newTestRoom(t, WithLookupsDuringShutdown())
+	h := room.hotel
+	// The client never reads, so the shutdown waits for it until ctx is done.
+	newTestClient(t, room, "alice")
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	done := make(chan error)
+	go func() { done <- h.ShutdownWithFarewell(ctx, "bye") }()
+	for !h.shuttingDown.Load() {
+		time.Sleep(time.Millisecond)
+	}
+
+	if got, err := h.GetOrCreateRoom("test"); err != nil || got != room {
+		t.Errorf("expected draining room to be returned, got %v", err)
+	}
+	if _, err := h.GetOrCreateRoom("new"); !errors.Is(err, ErrHotelShuttingDown) {
+		t.Errorf("expected ErrHotelShuttingDown, got %v", err)
+	}
+	if err := <-done; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected shutdown to time out, got %v", err)
+	}
+}
+
+func TestShutdownWaitsForCreators(t *testing.T) {
+	h := New(testInit, func(ctx context.Context, room *testRoom) {
+		<-ctx.Done()
+	})
+	// Play a creator that checked the shutdown flag just before it was set,
+	// and stores its room while the shutdown is already under way.
+	lock := h.lock("late")
+	lock.Lock()
+	done := make(chan error)
+	go func() { done <- h.Shutdown(context.Background()) }()
+	for !h.shuttingDown.Load() {
+		time.Sleep(time.Millisecond)
+	}
+	room := newRoom(h, "late", h.init, h.handler)
+	h.store.Put("late", room)
+	go h.trackRoom(room)
+	lock.Unlock()
+
+	if err := <-done; err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if room.ctx.Err() == nil {
+		t.Error("expected a room stored during the shutdown to be closed")
+	}
+}
+
+func TestRoomIntrospection(t *testing.T) {
+	h := New(testInit, func(ctx context.Context, room *testRoom) {
+		<-ctx.Done()
+	})
+	if _, ok := h.GetRoom("a"); ok {
+		t.Error("expected no room before it is created")
+	}
+	if h.RoomCount() != 0 {
+		t.Error("expected GetRoom not to create a room")
+	}
+	for _, id := range []string{"b", "a"} {
+		if _, err := h.GetOrCreateRoom(id); err != nil {
+			t.Fatalf("GetOrCreateRoom failed: %v", err)
+		}
+	}
+	if got := fmt.Sprint(h.RoomIDs()); got != "[a b]" {
+		t.Errorf("expected rooms [a b], got %s", got)
+	}
+	if n := h.RoomCount(); n != 2 {
+		t.Errorf("expected 2 rooms, got %d", n)
+	}
+	room := h.Room("a")
+	if room == nil || room.ID() != "a" {
+		t.Fatalf("expected room a, got %v", room)
+	}
+	room.Close()
+	if h.Room("a") != nil {
+		t.Error("expected closed room to be gone")
+	}
+	if n := h.RoomCount(); n != 1 {
+		t.Errorf("expected 1 room after close, got %d", n)
+	}
+}
+
+func TestRenameRoom(t *testing.T) {
+	room := newTestRoom(t)
+	h := room.hotel
+	client := newTestClient(t, room, "alice")
+	if _, err := h.GetOrCreateRoom("other"); err != nil {
+		t.Fatalf("GetOrCreateRoom failed: %v", err)
+	}
+
+	if err := h.RenameRoom("test", "renamed"); err != nil {
+		t.Fatalf("RenameRoom failed: %v", err)
+	}
+	if room.ID() != "renamed" {
+		t.Errorf("expected room ID renamed, got %q", room.ID())
+	}
+	if _, ok := h.GetRoom("test"); ok {
+		t.Error("expected old ID to be free")
+	}
+	if got, _ := h.GetRoom("renamed"); got != room {
+		t.Error("expected the room under its new ID")
+	}
+	if room.ClientCount() != 1 || client.Context().Err() != nil {
+		t.Error("expected the client to stay in the renamed room")
+	}
+
+	if err := h.RenameRoom("renamed", "other"); err == nil {
+		t.Error("expected an error renaming to a taken ID")
+	}
+	if err := h.RenameRoom("missing", "new"); err == nil {
+		t.Error("expected an error renaming a missing room")
+	}
+	if err := h.RenameRoom("renamed", ""); err == nil {
+		t.Error("expected an error renaming to an empty ID")
+	}
+
+	room.Close()
+	if h.Room("renamed") != nil {
+		t.Error("expected the closed room to be removed under its new ID")
+	}
+}
+
+func TestCloseIdleRooms(t *testing.T) {
+	clock := &testClock{now: time.Unix(0, 0)}
+	h := New(testInit, func(ctx context.Context, room *testRoom) {
+		for range room.Events() {
+		}
+	}, WithClock(clock), WithMinIdleDuration(time.Minute), WithAutoCloseDelay(-1))
+	idle, err := h.GetOrCreateRoom("idle")
+	if err != nil {
+		t.Fatalf("GetOrCreateRoom failed: %v", err)
+	}
+	busy, err := h.GetOrCreateRoom("busy")
+	if err != nil {
+		t.Fatalf("GetOrCreateRoom failed: %v", err)
+	}
+	t.Cleanup(busy.Close)
+	newTestClient(t, busy, "alice")
+	bob := newTestClient(t, idle, "bob")
+	clock.Advance(time.Hour)
+	idle.RemoveClient(bob)
+
+	clock.Advance(time.Minute - time.Second)
+	if n := h.CloseIdleRooms(); n != 0 {
+		t.Fatalf("expected no rooms to close before the minimum idle duration, got %d", n)
+	}
+	clock.Advance(time.Second)
+	if n := h.CloseIdleRooms(); n != 1 {
+		t.Fatalf("expected 1 room to close, got %d", n)
+	}
+	if idle.ctx.Err() == nil {
+		t.Error("expected the idle room to be closed")
+	}
+	if busy.ctx.Err() != nil {
+		t.Error("expected the occupied room to stay open")
+	}
+}
+
+func TestLobby(t *testing.T) {
+	h := New(testInit, func(ctx context.Context, room *testRoom) {
+		for range room.Events() {
+		}
+	})
+	if h.Lobby() != nil {
+		t.Fatal("expected no lobby before it's opened")
+	}
+	events := make(chan Event[testClientMetadata, string], 100)
+	lobby, err := h.OpenLobby(func(ctx context.Context, room *testRoom) {
+		for event := range room.Events() {
+			if event.RoomInfo != nil {
+				events <- event
+			}
+		}
+	})
+	if err != nil {
+		t.Fatalf("OpenLobby failed: %v", err)
+	}
+	defer lobby.Close()
+	if h.Lobby() != lobby {
+		t.Error("expected Lobby to return the open lobby")
+	}
+	if _, err := h.OpenLobby(nil); err == nil {
+		t.Error("expected an error opening a second lobby")
+	}
+
+	// Waits for the next lobby event of the given type, skipping others. A
+	// negative number of clients isn't checked.
+	expect := func(eventType EventType, clients int) {
+		t.Helper()
+		for {
+			event := receiveNext(t, events)
+			if event.Type != eventType {
+				continue
+			}
+			if event.RoomInfo.ID != "a" || (clients >= 0 && event.RoomInfo.ClientCount != clients) {
+				t.Errorf("expected %s for room a with %d clients, got %+v", eventType, clients, event.RoomInfo)
+			}
+			return
+		}
+	}
+	room, err := h.GetOrCreateRoom("a")
+	if err != nil {
+		t.Fatalf("GetOrCreateRoom failed: %v", err)
+	}
+	expect(EventRoomCreated, 0)
+	newTestClient(t, room, "alice")
+	expect(EventRoomOccupancyChanged, 1)
+	room.Close()
+	expect(EventRoomClosed, -1)
+
+	// An empty lobby doesn't wait to close.
+	client := newTestClient(t, lobby, "bob")
+	lobby.RemoveClient(client)
+	lobby.closeTimerMu.Lock()
+	timer := lobby.closeTimer
+	lobby.closeTimerMu.Unlock()
+	if timer != nil || lobby.ctx.Err() != nil {
+		t.Error("expected the empty lobby to stay open without a close timer")
+	}
+}
+
+func TestCreationRateLimit(t *testing.T) {
+	h := New(testInit, func(ctx context.Context, room *testRoom) {
+		<-ctx.Done()
+	}, WithCreationRateLimit(0.001, 2))
+	defer h.Shutdown(context.Background())
+
+	for _, id := range []string{"a", "b"} {
+		if _, err := h.GetOrCreateRoomFrom("1.2.3.4", id); err != nil {
+			t.Fatalf("GetOrCreateRoomFrom failed within the burst: %v", err)
+		}
+	}
+	if _, err := h.GetOrCreateRoomFrom("1.2.3.4", "c"); !errors.Is(err, ErrCreationRateLimited) {
+		t.Errorf("expected ErrCreationRateLimited, got %v", err)
+	}
+	if _, err := h.GetOrCreateRoomFrom("1.2.3.4", "a"); err != nil {
+		t.Errorf("expected getting an existing room not to be limited, got %v", err)
+	}
+	if _, err := h.GetOrCreateRoomFrom("", "d"); err != nil {
+		t.Errorf("expected an empty source not to be limited, got %v", err)
+	}
+	if _, err := h.GetOrCreateRoomFrom("5.6.7.8", "e"); err != nil {
+		t.Errorf("expected another source to have its own limit, got %v", err)
+	}
+}
+
+func TestKeyedLimiterSweep(t *testing.T) {
+	l := newKeyedLimiter(1, 1)
+	l.sweepSize = 2
+	l.allow("a")
+	l.allow("b")
+	// Neither bucket has refilled yet, so both are kept.
+	l.sweep(time.Now())
+	if n := len(l.buckets); n != 2 {
+		t.Fatalf("expected 2 buckets to be kept, got %d", n)
+	}
+	if l.sweepSize != 1024 {
+		t.Errorf("expected the sweep size to be reset to 1024, got %d", l.sweepSize)
+	}
+	l.sweep(time.Now().Add(time.Minute))
+	if n := len(l.buckets); n != 0 {
+		t.Errorf("expected refilled buckets to be forgotten, got %d", n)
+	}
+	if !l.allow("a") {
+		t.Error("expected a forgotten key to start with a full bucket")
+	}
+}
+
+func TestTopRooms(t *testing.T) {
+	h := New(testInit, func(ctx context.Context, room *testRoom) {
+		for range room.Events() {
+		}
+	})
+	defer h.Shutdown(context.Background())
+	rooms := make(map[string]*testRoom)
+	for _, id := range []string{"old", "busy", "new"} {
+		room, err := h.GetOrCreateRoom(id)
+		if err != nil {
+			t.Fatalf("GetOrCreateRoom failed: %v", err)
+		}
+		rooms[id] = room
+		// Keeps the creation times apart.
+		time.Sleep(time.Millisecond)
+	}
+	for _, name := range []string{"alice", "bob"} {
+		newTestClient(t, rooms["busy"], name)
+	}
+	for range 10 {
+		rooms["old"].Emit(Event[testClientMetadata, string]{Type: EventCustom})
+	}
+
+	info := rooms["busy"].Info()
+	if info.ID != "busy" || info.ClientCount != 2 || info.EventCount == 0 || info.CreatedAt.IsZero() || info.LastActivity.Before(info.CreatedAt) {
+		t.Errorf("unexpected info %+v", info)
+	}
+	ids := func(infos []RoomInfo) string {
+		var ids []string
+		for _, info := range infos {
+			ids = append(ids, info.ID)
+		}
+		return fmt.Sprint(ids)
+	}
+	tests := []struct {
+		n    int
+		by   SortKey
+		want string
+	}{
+		{1, SortByClients, "[busy]"},
+		{1, SortByEvents, "[old]"},
+		{-1, SortByAge, "[old busy new]"},
+		{2, SortByAge, "[old busy]"},
+		{10, SortByAge, "[old busy new]"},
+	}
+	for _, test := range tests {
+		if got := ids(h.TopRooms(test.n, test.by)); got != test.want {
+			t.Errorf("TopRooms(%d, %s) = %s, want %s", test.n, test.by, got, test.want)
+		}
+	}
+}
+
+func TestInitTimeout(t *testing.T) {
+	h := New(func(ctx context.Context, id string) (*testRoomMetadata, error) {
+		if id == "slow" {
+			// Ignores ctx, like an init stuck on a blocking call.
+			time.Sleep(time.Second)
+		}
+		return &testRoomMetadata{}, nil
+	}, func(ctx context.Context, room *testRoom) {
+		<-ctx.Done()
+	}, WithInitTimeout(10*time.Millisecond))
+
+	start := time.Now()
+	if _, err := h.GetOrCreateRoom("slow"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline error, got %v", err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("expected GetOrCreateRoom to return at the deadline, took %v", d)
+	}
+	room, err := h.GetOrCreateRoom("fast")
+	if err != nil {
+		t.Fatalf("GetOrCreateRoom failed: %v", err)
+	}
+	defer room.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, ok := h.store.Get("slow"); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected timed out room to be removed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestGetOrCreateRoomContextWaiters(t *testing.T) {
+	release := make(chan struct{})
+	h := New(func(ctx context.Context, id string) (*testRoomMetadata, error) {
+		<-release
+		return &testRoomMetadata{}, nil
+	}, func(ctx context.Context, room *testRoom) {
+		<-ctx.Done()
+	}, WithMaxWaitersPerRoom(1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := h.GetOrCreateRoomContext(ctx, "slow"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline error, got %v", err)
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		room, err := h.GetOrCreateRoomContext(context.Background(), "slow")
+		if err == nil {
+			defer room.Close()
+		}
+		result <- err
+	}()
+	room, _ := h.store.Get("slow")
+	deadline := time.Now().Add(time.Second)
+	for room.waiters.Load() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected a caller to be waiting for init")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := h.GetOrCreateRoom("slow"); !errors.Is(err, ErrTooManyWaiters) {
+		t.Errorf("expected ErrTooManyWaiters, got %v", err)
+	}
+
+	close(release)
+	if err := <-result; err != nil {
+		t.Errorf("expected the waiting caller to get the room, got %v", err)
+	}
+}
+
+type testLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *testLogger) Warnf(format string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, "warn: "+fmt.Sprintf(format, args...))
+}
+
+func (l *testLogger) Errorf(format string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, "error: "+fmt.Sprintf(format, args...))
+}
+
+func TestLogger(t *testing.T) {
+	logger := &testLogger{}
+	restarted := make(chan struct{})
+	var calls atomic.Int32
+	newTestRoomWithHandler(t, func(ctx context.Context, room *testRoom) {
+		if calls.Add(1) == 1 {
+			panic("boom")
+		}
+		close(restarted)
+		<-ctx.Done()
+	}, WithHandlerRestart(1, nil), WithLogger(logger))
+
+	<-restarted
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	if len(logger.messages) != 2 || !strings.HasPrefix(logger.messages[0], "error: Room test handler panicked: boom") || logger.messages[1] != "warn: Room test restarting handler (restart 1 of 1)" {
+		t.Errorf("unexpected log messages %q", logger.messages)
+	}
+}
+
+// TestLoggerLines pins the line each log message is written to a Logger as,
+// logged the way the hotel logs it.
+func TestLoggerLines(t *testing.T) {
+	errBoom := errors.New("boom")
+	tests := []struct {
+		msg  string
+		args []any
+		want string
+	}{
+		{"room init failed", []any{"error", errBoom, "duration", time.Second}, "warn: Room lobby init failed: boom"},
+		{"room init panicked", []any{"panic", "boom", "stack", "trace"}, "error: Room lobby init panicked: boom\ntrace"},
+		{"room handler panicked", []any{"panic", "boom", "stack", "trace"}, "error: Room lobby handler panicked: boom\ntrace"},
+		{"restarting room handler", []any{"restart", 1, "max_restarts", 3}, "warn: Room lobby restarting handler (restart 1 of 3)"},
+		{"room events channel is full, closing room", []any{"event", EventJoin}, "warn: Room lobby events channel is full. Cannot send EventJoin. Closing room."},
+		{"room events channel is full, dropping events", []any{"event", EventJoin}, "warn: Room lobby events channel is full. Dropping EventJoin."},
+		{"failed to send data to client", []any{"client.id", "c1", "client.metadata", "alice", "error", errBoom}, "error: Failed to send data to client c1: boom"},
+		{"failed to encode data for client", []any{"client.id", "c1", "error", errBoom}, "error: Failed to encode data for client c1: boom"},
+		{"failed to decode data for client", []any{"client.id", "c1", "error", errBoom}, "error: Failed to decode data for client c1: boom"},
+		{"room handler stopped", []any{"reason", "closed"}, "warn: room handler stopped room.id=lobby reason=closed"},
+	}
+	tested := make(map[string]bool)
+	for _, test := range tests {
+		tested[test.msg] = true
+		logger := &testLogger{}
+		log := slog.New(&loggerHandler{logger: logger}).With("room.id", "lobby")
+		if strings.HasPrefix(test.want, "error: ") {
+			log.Error(test.msg, test.args...)
+		} else {
+			log.Warn(test.msg, test.args...)
+		}
+		if len(logger.messages) != 1 || logger.messages[0] != test.want {
+			t.Errorf("%q logged %q, want %q", test.msg, logger.messages, test.want)
+		}
+	}
+	for msg := range lineFormats {
+		if !tested[msg] {
+			t.Errorf("no test for the line %q is written as", msg)
+		}
+	}
+}
+
+func TestSlog(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	newTestRoom(t, WithSlog(logger))
+
+	// Both are logged before GetOrCreateRoom returns.
+	var got []string
+	decoder := json.NewDecoder(&buf)
+	for decoder.More() {
+		var record struct {
+			Msg    string `json:"msg"`
+			RoomID string `json:"room.id"`
+		}
+		if err := decoder.Decode(&record); err != nil {
+			t.Fatalf("expected JSON log records: %v", err)
+		}
+		got = append(got, record.RoomID+": "+record.Msg)
+	}
+	if fmt.Sprint(got) != "[test: room handler started test: room initialized]" {
+		t.Errorf("unexpected log records %q", got)
+	}
+}
+
+type testMetrics struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (m *testMetrics) count(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counts[name]++
+}
+
+func (m *testMetrics) InitCompleted(roomID string, duration time.Duration, err error) {}
+func (m *testMetrics) RoomCreated(roomID string)                                      { m.count("created") }
+func (m *testMetrics) RoomClosed(roomID string)                                       { m.count("closed") }
+func (m *testMetrics) ClientJoined(roomID string)                                     { m.count("joined") }
+func (m *testMetrics) ClientLeft(roomID string, reason LeaveReason)                   { m.count("left") }
+func (m *testMetrics) EventDropped(roomID string, eventType EventType)                { m.count("dropped") }
+func (m *testMetrics) EventChannelDepth(roomID string, n int)                         { m.count("depth") }
+
+func TestMetricsObserver(t *testing.T) {
+	metrics := &testMetrics{counts: make(map[string]int)}
+	room := newTestRoom(t, WithObserver(metrics))
+	client := newTestClient(t, room, "alice")
+	room.RemoveClient(client)
+	room.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		metrics.mu.Lock()
+		counts := fmt.Sprint(metrics.counts)
+		metrics.mu.Unlock()
+		// Occupied, join, leave and empty events were queued.
+		if counts == "map[closed:1 created:1 depth:4 joined:1 left:1]" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected metrics %s", counts)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
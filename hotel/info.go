@@ -0,0 +1,80 @@
+package hotel
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// RoomInfo is a snapshot of a room's activity.
+type RoomInfo struct {
+	ID           string
+	Name         string
+	ClientCount  int
+	EventCount   uint64
+	CreatedAt    time.Time
+	LastActivity time.Time
+}
+
+type SortKey int
+
+const (
+	// SortByClients sorts rooms with the most clients first.
+	SortByClients SortKey = iota
+	// SortByEvents sorts rooms with the most events emitted first.
+	SortByEvents
+	// SortByAge sorts the oldest rooms first.
+	SortByAge
+)
+
+func (k SortKey) String() string {
+	switch k {
+	case SortByClients:
+		return "SortByClients"
+	case SortByEvents:
+		return "SortByEvents"
+	case SortByAge:
+		return "SortByAge"
+	}
+	return fmt.Sprintf("<!SortKey %d>", k)
+}
+
+// Info returns a snapshot of the room's activity.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) Info() RoomInfo {
+	r.mu.RLock()
+	clientCount := len(r.clients)
+	r.mu.RUnlock()
+	return RoomInfo{
+		ID:           r.ID(),
+		Name:         r.Name(),
+		ClientCount:  clientCount,
+		EventCount:   r.eventCount.Load(),
+		CreatedAt:    r.createdAt,
+		LastActivity: time.Unix(0, r.lastActivity.Load()),
+	}
+}
+
+// TopRooms returns snapshots of the top n rooms according to the sort key. If
+// n is negative, all rooms are returned.
+func (h *Hotel[RoomMetadata, ClientMetadata, DataType]) TopRooms(n int, by SortKey) []RoomInfo {
+	rooms := h.allRooms()
+	infos := make([]RoomInfo, len(rooms))
+	for i, room := range rooms {
+		infos[i] = room.Info()
+	}
+	slices.SortFunc(infos, func(a, b RoomInfo) int {
+		switch by {
+		case SortByEvents:
+			return cmp.Compare(b.EventCount, a.EventCount)
+		case SortByAge:
+			return a.CreatedAt.Compare(b.CreatedAt)
+		default:
+			return cmp.Compare(b.ClientCount, a.ClientCount)
+		}
+	})
+	if n >= 0 && n < len(infos) {
+		infos = infos[:n]
+	}
+	return infos
+}
@@ -0,0 +1,59 @@
+package hotel
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// latencyWindow is the number of most recent samples LatencyStats covers.
+const latencyWindow = 128
+
+// LatencyStats summarizes how long recent messages spent inside the package,
+// from being sent to a client until the consumer read them.
+type LatencyStats struct {
+	// Count is the total number of samples recorded, while the durations below
+	// only cover the most recent samples.
+	Count int
+	Mean  time.Duration
+	P50   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+// latencyRecorder keeps a rolling window of latency samples.
+type latencyRecorder struct {
+	mu      sync.Mutex
+	samples [latencyWindow]time.Duration
+	count   int
+}
+
+func (l *latencyRecorder) record(d time.Duration) {
+	l.mu.Lock()
+	l.samples[l.count%latencyWindow] = d
+	l.count++
+	l.mu.Unlock()
+}
+
+func (l *latencyRecorder) stats() LatencyStats {
+	l.mu.Lock()
+	n := min(l.count, latencyWindow)
+	samples := slices.Clone(l.samples[:n])
+	count := l.count
+	l.mu.Unlock()
+
+	stats := LatencyStats{Count: count}
+	if n == 0 {
+		return stats
+	}
+	slices.Sort(samples)
+	var total time.Duration
+	for _, d := range samples {
+		total += d
+	}
+	stats.Mean = total / time.Duration(n)
+	stats.P50 = samples[n*50/100]
+	stats.P99 = samples[n*99/100]
+	stats.Max = samples[n-1]
+	return stats
+}
@@ -0,0 +1,53 @@
+package hotel
+
+import (
+	"context"
+	"errors"
+)
+
+// OpenLobby creates the hotel's lobby: a room that isn't part of the hotel's
+// rooms, but receives an event whenever a room in the hotel is created,
+// closed or changes its number of clients (EventRoomCreated, EventRoomClosed
+// and EventRoomOccupancyChanged). The handler typically turns these into
+// broadcasts, so that clients in the lobby can browse the hotel's rooms. The
+// hotel's init function is not run for the lobby, so its metadata is the zero
+// value. Only one lobby can be open at a time.
+func (h *Hotel[RoomMetadata, ClientMetadata, DataType]) OpenLobby(handler RoomHandlerFunc[RoomMetadata, ClientMetadata, DataType]) (*Room[RoomMetadata, ClientMetadata, DataType], error) {
+	h.lobbyMu.Lock()
+	if h.lobby != nil && !h.lobby.closed.Load() {
+		h.lobbyMu.Unlock()
+		return nil, errors.New("lobby is already open")
+	}
+	lobby := newRoom(h, "lobby", func(ctx context.Context, id string) (*RoomMetadata, error) {
+		return new(RoomMetadata), nil
+	}, handler)
+	// The lobby stays open even when nobody is browsing.
+	lobby.SetAutoCloseDelay(-1)
+	h.lobby = lobby
+	h.lobbyMu.Unlock()
+
+	if err := lobby.waitInit(context.Background(), 0); err != nil {
+		return nil, err
+	}
+	return lobby, nil
+}
+
+// Lobby returns the lobby opened with OpenLobby, or nil if there is none.
+func (h *Hotel[RoomMetadata, ClientMetadata, DataType]) Lobby() *Room[RoomMetadata, ClientMetadata, DataType] {
+	h.lobbyMu.RLock()
+	defer h.lobbyMu.RUnlock()
+	return h.lobby
+}
+
+// notifyLobby emits a room lifecycle event into the lobby, if there is one.
+func (h *Hotel[RoomMetadata, ClientMetadata, DataType]) notifyLobby(eventType EventType, room *Room[RoomMetadata, ClientMetadata, DataType]) {
+	lobby := h.Lobby()
+	if lobby == nil || lobby == room || lobby.closed.Load() {
+		return
+	}
+	info := room.Info()
+	lobby.Emit(Event[ClientMetadata, DataType]{
+		Type:     eventType,
+		RoomInfo: &info,
+	})
+}
@@ -0,0 +1,123 @@
+package hotel
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// Logger receives the hotel's warnings and errors as formatted lines, e.g. to
+// route them into a logging library. It must be safe for concurrent use. Use
+// WithSlog instead for structured logging.
+type Logger interface {
+	// Warnf logs problems the hotel recovered from, such as a room closing
+	// because its handler fell behind.
+	Warnf(format string, args ...any)
+	// Errorf logs failures, such as panics (with stack traces) and data that
+	// couldn't be delivered to a client.
+	Errorf(format string, args ...any)
+}
+
+// stdLogger is the default Logger, which writes to the standard log package.
+type stdLogger struct{}
+
+func (stdLogger) Warnf(format string, args ...any) {
+	log.Printf("Warning: "+format, args...)
+}
+
+func (stdLogger) Errorf(format string, args ...any) {
+	log.Printf(format, args...)
+}
+
+// loggerHandler is a slog.Handler that formats warnings and errors as the
+// lines a Logger has always received, and ignores anything less severe.
+type loggerHandler struct {
+	logger Logger
+	attrs  []slog.Attr // Added with WithAttrs, with group prefixes applied.
+	group  string      // Prefix for attribute keys, from WithGroup.
+}
+
+// lineFormat is the printf-style line a log message is written to a Logger
+// as, filled in with the values of the listed attributes.
+type lineFormat struct {
+	format string
+	keys   []string
+}
+
+// lineFormats maps the hotel's log messages to their Logger lines. Messages
+// without an entry are written as the message followed by its attributes.
+var lineFormats = map[string]lineFormat{
+	"room init failed":                             {"Room %s init failed: %v", []string{"room.id", "error"}},
+	"room init panicked":                           {"Room %s init panicked: %v\n%s", []string{"room.id", "panic", "stack"}},
+	"room handler panicked":                        {"Room %s handler panicked: %v\n%s", []string{"room.id", "panic", "stack"}},
+	"restarting room handler":                      {"Room %s restarting handler (restart %d of %d)", []string{"room.id", "restart", "max_restarts"}},
+	"room events channel is full, closing room":    {"Room %s events channel is full. Cannot send %s. Closing room.", []string{"room.id", "event"}},
+	"room events channel is full, dropping events": {"Room %s events channel is full. Dropping %s.", []string{"room.id", "event"}},
+	"failed to send data to client":                {"Failed to send data to client %s: %v", []string{"client.id", "error"}},
+	"failed to encode data for client":             {"Failed to encode data for client %s: %v", []string{"client.id", "error"}},
+	"failed to decode data for client":             {"Failed to decode data for client %s: %v", []string{"client.id", "error"}},
+}
+
+func (h *loggerHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= slog.LevelWarn
+}
+
+func (h *loggerHandler) Handle(ctx context.Context, record slog.Record) error {
+	attrs := slices.Clone(h.attrs)
+	record.Attrs(func(attr slog.Attr) bool {
+		attrs = appendAttr(attrs, h.group, attr)
+		return true
+	})
+	line := formatLine(record.Message, attrs)
+	if record.Level >= slog.LevelError {
+		h.logger.Errorf("%s", line)
+	} else {
+		h.logger.Warnf("%s", line)
+	}
+	return nil
+}
+
+func (h *loggerHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	all := slices.Clone(h.attrs)
+	for _, attr := range attrs {
+		all = appendAttr(all, h.group, attr)
+	}
+	return &loggerHandler{logger: h.logger, attrs: all, group: h.group}
+}
+
+func (h *loggerHandler) WithGroup(name string) slog.Handler {
+	return &loggerHandler{logger: h.logger, attrs: h.attrs, group: h.group + name + "."}
+}
+
+func appendAttr(attrs []slog.Attr, group string, attr slog.Attr) []slog.Attr {
+	if attr.Equal(slog.Attr{}) {
+		return attrs
+	}
+	return append(attrs, slog.Attr{Key: group + attr.Key, Value: attr.Value.Resolve()})
+}
+
+// formatLine formats a log message and its attributes as a Logger line.
+func formatLine(msg string, attrs []slog.Attr) string {
+	if f, ok := lineFormats[msg]; ok {
+		args := make([]any, 0, len(f.keys))
+		for _, key := range f.keys {
+			i := slices.IndexFunc(attrs, func(attr slog.Attr) bool { return attr.Key == key })
+			if i < 0 {
+				break
+			}
+			args = append(args, attrs[i].Value.Any())
+		}
+		if len(args) == len(f.keys) {
+			return fmt.Sprintf(f.format, args...)
+		}
+	}
+	var b strings.Builder
+	b.WriteString(msg)
+	for _, attr := range attrs {
+		fmt.Fprintf(&b, " %s=%v", attr.Key, attr.Value)
+	}
+	return b.String()
+}
@@ -2,12 +2,13 @@ package hotel
 
 import (
 	"fmt"
+	"maps"
 	"reflect"
+	"slices"
 )
 
-// TODO: Add in Envelope and User concepts
+// TODO: Add in an Envelope concept
 // Envelope points at a User and a Message
-// User is backed by one or more Client instances (authed and has id)
 
 type Message interface {
 	Type() string
@@ -15,14 +16,39 @@ type Message interface {
 
 type MessageRegistry[M Message] map[string]reflect.Type
 
-// Register adds one or more message types to the registry.
+// Register adds one or more message types to the registry. It panics if a
+// message type was already registered; see RegisterErr.
 func (r MessageRegistry[M]) Register(msgs ...M) {
+	if err := r.RegisterErr(msgs...); err != nil {
+		panic(err.Error())
+	}
+}
+
+// RegisterErr is like Register, but returns an error instead of panicking. If
+// any of the message types conflict, none of them are added.
+func (r MessageRegistry[M]) RegisterErr(msgs ...M) error {
+	seen := make(map[string]bool, len(msgs))
 	for _, msg := range msgs {
-		if _, ok := r[msg.Type()]; ok {
-			panic(fmt.Sprintf("Message type %q was already registered", msg.Type()))
+		if _, ok := r[msg.Type()]; ok || seen[msg.Type()] {
+			return fmt.Errorf("message type %q already registered", msg.Type())
 		}
+		seen[msg.Type()] = true
+	}
+	for _, msg := range msgs {
 		r[msg.Type()] = reflect.TypeOf(msg).Elem()
 	}
+	return nil
+}
+
+// Types returns the sorted names of all the registered message types.
+func (r MessageRegistry[M]) Types() []string {
+	return slices.Sorted(maps.Keys(r))
+}
+
+// Has reports whether the message type is registered.
+func (r MessageRegistry[M]) Has(msgType string) bool {
+	_, ok := r[msgType]
+	return ok
 }
 
 func (r MessageRegistry[M]) Create(msgType string) (msg M, err error) {
@@ -32,3 +58,20 @@ func (r MessageRegistry[M]) Create(msgType string) (msg M, err error) {
 	err = fmt.Errorf("unknown message type: %q", msgType)
 	return
 }
+
+// Encode encodes msg in the same "type json" format as a JSONCodec without
+// compression, so that the registry itself can be used as a Codec.
+func (r MessageRegistry[M]) Encode(msg M) ([]byte, error) {
+	return NewJSONCodec(r).Encode(msg)
+}
+
+// Decode decodes a "type json" frame into a new message of the registered
+// type.
+func (r MessageRegistry[M]) Decode(data []byte) (M, error) {
+	return NewJSONCodec(r).Decode(data)
+}
+
+// Binary reports false, since the registry's frames are always text.
+func (r MessageRegistry[M]) Binary() bool {
+	return false
+}
@@ -0,0 +1,319 @@
+package hotel
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Option configures a Hotel created with New.
+type Option func(*options)
+
+type options struct {
+	// codec is a Codec[DataType], but options aren't generic so it's checked
+	// against the hotel's DataType in New.
+	codec any
+
+	handlerRestarts int
+	handlerBackoff  func(n int) time.Duration
+
+	trackLatency bool
+
+	creationRate  float64
+	creationBurst int
+
+	clientDataRate  float64
+	clientDataBurst int
+
+	diagnosticsBuffer int
+
+	shards int
+
+	minIdle time.Duration
+
+	maxWaiters int
+
+	historySize int
+
+	evictAfter int
+
+	maxMessageSize int
+
+	dependencies any
+
+	directDelivery bool
+
+	clientBufferSize int
+	overflow         OverflowPolicy
+
+	observer Observer
+
+	logger Logger
+	slog   *slog.Logger
+
+	lookupsDuringShutdown bool
+
+	initTimeout time.Duration
+
+	autoCloseDelay    time.Duration
+	autoCloseDelaySet bool
+
+	maxClients int
+
+	eventOverflow EventOverflowPolicy
+
+	clock Clock
+
+	// store is a RoomStore, checked against the hotel's types in New.
+	store any
+}
+
+// WithCodec sets the codec rooms use to convert between DataType and bytes,
+// which enables byte-level APIs such as Room.SendToClientBytes and
+// Client.ReceiveBytes. If the codec doesn't encode the hotel's DataType, New
+// panics.
+func WithCodec[DataType any](codec Codec[DataType]) Option {
+	return func(o *options) {
+		o.codec = codec
+	}
+}
+
+// WithHandlerRestart restarts a room's handler when it panics, keeping its
+// clients connected, instead of closing the room. The room is only closed
+// after maxRestarts restarts. If backoff is non-nil, it's called with the
+// restart number (starting at 1) to get the delay before each restart.
+func WithHandlerRestart(maxRestarts int, backoff func(n int) time.Duration) Option {
+	return func(o *options) {
+		o.handlerRestarts = maxRestarts
+		o.handlerBackoff = backoff
+	}
+}
+
+// WithLatencyTracking makes clients record how long each message spends in
+// their buffer, which is then available through Client.SendLatency.
+func WithLatencyTracking() Option {
+	return func(o *options) {
+		o.trackLatency = true
+	}
+}
+
+// WithClientRateLimit limits how quickly each client may send data to rooms
+// through Room.HandleClientData and Room.HandleClientBytes, to rate messages
+// per second with bursts of up to burst messages. Data over the limit is
+// rejected with ErrClientRateLimited instead of being emitted to the handler,
+// so a flooding client can't overflow the room's events.
+func WithClientRateLimit(rate float64, burst int) Option {
+	return func(o *options) {
+		o.clientDataRate = rate
+		o.clientDataBurst = burst
+	}
+}
+
+// WithCreationRateLimit limits how quickly each source passed to
+// GetOrCreateRoomFrom may create new rooms, to rate per second with bursts of
+// up to burst rooms.
+func WithCreationRateLimit(rate float64, burst int) Option {
+	return func(o *options) {
+		o.creationRate = rate
+		o.creationBurst = burst
+	}
+}
+
+// WithEventDiagnostics mirrors every room's events to a channel available
+// through Room.DiagnosticEvents, buffering up to bufferSize events.
+func WithEventDiagnostics(bufferSize int) Option {
+	return func(o *options) {
+		o.diagnosticsBuffer = bufferSize
+	}
+}
+
+// WithShards splits the hotel's room storage across n maps with separate
+// locks, which reduces lock contention when there are very many rooms being
+// looked up, created and closed concurrently. With a custom RoomStore, it only
+// affects the locks the hotel uses to coordinate changes to the store.
+func WithShards(n int) Option {
+	return func(o *options) {
+		o.shards = n
+	}
+}
+
+// WithMinIdleDuration sets how long a room must have been empty before
+// Hotel.CloseIdleRooms closes it, so that rooms that only just emptied are
+// left alone.
+func WithMinIdleDuration(d time.Duration) Option {
+	return func(o *options) {
+		o.minIdle = d
+	}
+}
+
+// WithMaxWaitersPerRoom limits how many callers may wait for a room's init to
+// finish at the same time. Beyond that, GetOrCreateRoom fails immediately
+// with ErrTooManyWaiters instead of piling up behind a slow init.
+func WithMaxWaitersPerRoom(n int) Option {
+	return func(o *options) {
+		o.maxWaiters = n
+	}
+}
+
+// WithHistory makes every room keep the last n messages broadcast in it,
+// available through Room.History and Room.HistorySince. Memory use is bounded
+// by n messages per room.
+func WithHistory(n int) Option {
+	return func(o *options) {
+		o.historySize = n
+	}
+}
+
+// WithEvictAfter makes clients tolerate full buffers: data sent to a client
+// with a full buffer is dropped (see Client.DropCount), and the client is
+// only disconnected after k consecutive drops. By default, a client is
+// disconnected the first time its buffer is full.
+func WithEvictAfter(k int) Option {
+	return func(o *options) {
+		o.evictAfter = k
+	}
+}
+
+// WithMaxMessageSize makes Room.HandleClientBytes reject inbound messages
+// larger than the given number of bytes with ErrMessageTooLarge, before they
+// are decoded. Compressed messages are also rejected if they inflate past the
+// limit, as long as the codec is a LimitedDecoder (like JSONCodec). Transports
+// should also limit how much they read accordingly.
+func WithMaxMessageSize(bytes int) Option {
+	return func(o *options) {
+		o.maxMessageSize = bytes
+	}
+}
+
+type dependenciesKey struct{}
+
+// WithDependencies attaches a value, such as a struct holding a database
+// handle and config, to the contexts passed to every room's init function and
+// handler. Use Dependencies to retrieve it, instead of relying on globals.
+func WithDependencies(deps any) Option {
+	return func(o *options) {
+		o.dependencies = deps
+	}
+}
+
+// Dependencies returns the value set with WithDependencies from a room's
+// context. It returns false if there's no such value or it's not a T.
+func Dependencies[T any](ctx context.Context) (T, bool) {
+	deps, ok := ctx.Value(dependenciesKey{}).(T)
+	return deps, ok
+}
+
+// WithClientBuffer sets how many items each client buffers for its consumer
+// (DefaultClientBufferSize by default), and what happens when data is sent to
+// a client whose buffer is full (OverflowDisconnect by default).
+func WithClientBuffer(size int, policy OverflowPolicy) Option {
+	return func(o *options) {
+		o.clientBufferSize = size
+		o.overflow = policy
+	}
+}
+
+// WithDirectDelivery removes the buffer between sending data to a client and
+// the client's consumer receiving it: a send only succeeds if the consumer is
+// waiting to receive at that moment, otherwise the data is dropped as if the
+// buffer was full (see WithEvictAfter). This minimizes latency, since data
+// never waits behind a backlog, at the cost of throughput and of dropping
+// data whenever the consumer is busy, e.g. writing the previous message.
+func WithDirectDelivery() Option {
+	return func(o *options) {
+		o.directDelivery = true
+	}
+}
+
+// WithObserver sets an Observer that's notified about the hotel's operation.
+// It's also notified about more if it implements EventObserver or
+// MetricsObserver.
+func WithObserver(observer Observer) Option {
+	return func(o *options) {
+		o.observer = observer
+	}
+}
+
+// WithLogger sends the hotel's warnings and errors to logger instead of the
+// standard log package.
+func WithLogger(logger Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithSlog sends the hotel's log messages to logger, with attributes such as
+// room.id attached, instead of the standard log package. This includes debug
+// messages about the lifecycle of rooms. It takes precedence over WithLogger.
+func WithSlog(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.slog = logger
+	}
+}
+
+// WithRoomStore makes the hotel keep track of its rooms in the given
+// RoomStore instead of in memory. If store isn't a RoomStore for the hotel's
+// types, New panics.
+func WithRoomStore(store any) Option {
+	return func(o *options) {
+		o.store = store
+	}
+}
+
+// WithLookupsDuringShutdown lets GetOrCreateRoom keep returning rooms that
+// already exist while Hotel.Shutdown is draining them. New rooms are never
+// created during a shutdown.
+func WithLookupsDuringShutdown() Option {
+	return func(o *options) {
+		o.lookupsDuringShutdown = true
+	}
+}
+
+// WithAutoCloseDelay sets how long rooms stay open after their last client
+// leaves, instead of DefaultAutoCloseDelay. Zero closes rooms as soon as
+// they're empty, and a negative delay means rooms never close by themselves.
+// Room.SetAutoCloseDelay overrides it for a single room.
+func WithAutoCloseDelay(d time.Duration) Option {
+	return func(o *options) {
+		o.autoCloseDelay = d
+		o.autoCloseDelaySet = true
+	}
+}
+
+// WithInitTimeout limits how long a room's init function may run. The
+// context passed to init is cancelled after d, and if init hasn't returned by
+// then, the room is discarded and GetOrCreateRoom returns an error wrapping
+// context.DeadlineExceeded.
+func WithInitTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.initTimeout = d
+	}
+}
+
+// WithMaxClients limits every room to n clients, after which adding a client
+// fails with ErrRoomFull. Room.SetMaxClients overrides it for a single room.
+func WithMaxClients(n int) Option {
+	return func(o *options) {
+		o.maxClients = n
+	}
+}
+
+// WithEventOverflow sets what rooms do when their handler falls so far behind
+// that an event doesn't fit in the events channel. The default,
+// EventOverflowClose, closes the room.
+func WithEventOverflow(policy EventOverflowPolicy) Option {
+	return func(o *options) {
+		o.eventOverflow = policy
+	}
+}
+
+// WithClock sets the clock that rooms use for their timers (auto-close, tickers
+// started with Room.StartTicker and EventOverflowBlock's wait) and for their
+// clients' activity times, instead of the real time. This is mainly for tests,
+// which can use a fake clock to check time-driven behavior without waiting for
+// it.
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
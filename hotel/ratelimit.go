@@ -0,0 +1,84 @@
+package hotel
+
+import (
+	"sync"
+	"time"
+)
+
+// tokenBucket allows bursts of up to burst events, refilling at rate tokens
+// per second. It is not safe for concurrent use on its own.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// keyedLimiter keeps a token bucket per key, e.g. per source address.
+type keyedLimiter struct {
+	rate  float64
+	burst int
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	sweepSize int
+}
+
+func newKeyedLimiter(rate float64, burst int) *keyedLimiter {
+	return &keyedLimiter{
+		rate:      rate,
+		burst:     burst,
+		buckets:   make(map[string]*tokenBucket),
+		sweepSize: 1024,
+	}
+}
+
+func (l *keyedLimiter) allow(key string) bool {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= l.sweepSize {
+			l.sweep(now)
+		}
+		b = newTokenBucket(l.rate, l.burst, now)
+		l.buckets[key] = b
+	}
+	return b.allow(now)
+}
+
+// sweep forgets buckets that have refilled completely, since they behave
+// exactly like new ones. This keeps the map from growing with every key ever
+// seen.
+func (l *keyedLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.refill(now); b.tokens >= b.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.sweepSize = max(1024, 2*len(l.buckets))
+}
@@ -0,0 +1,34 @@
+package hotel
+
+// LeaveReason describes why a client was closed. Applications may use their
+// own reasons in addition to the ones defined here. It's also the cause of the
+// client's context being cancelled, so a reason can be recovered with
+// context.Cause(client.Context()).
+type LeaveReason string
+
+func (r LeaveReason) Error() string {
+	return "client closed: " + string(r)
+}
+
+const (
+	// ReasonClosed means the client was closed without a specific reason.
+	ReasonClosed LeaveReason = "closed"
+	// ReasonRemoved means the client was removed from its room.
+	ReasonRemoved LeaveReason = "removed"
+	// ReasonBufferFull means the client didn't read data fast enough.
+	ReasonBufferFull LeaveReason = "buffer full"
+	// ReasonRoomClosed means the client's room was closed.
+	ReasonRoomClosed LeaveReason = "room closed"
+	// ReasonRejected means a new client was turned away by its room, because
+	// it was full or its admission function didn't admit the client.
+	ReasonRejected LeaveReason = "rejected"
+	// ReasonKicked means the application kicked the client.
+	ReasonKicked LeaveReason = "kicked"
+	// ReasonBanned means the application banned the client.
+	ReasonBanned LeaveReason = "banned"
+	// ReasonShutdown means the hotel was shut down with Hotel.Shutdown.
+	ReasonShutdown LeaveReason = "server shutdown"
+	// ReasonTransferred means the client left for another room without being
+	// closed. It's only used for leave events.
+	ReasonTransferred LeaveReason = "transferred"
+)
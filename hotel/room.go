@@ -1,170 +1,916 @@
 package hotel
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"maps"
 	"runtime"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
-
-	"golang.org/x/sync/errgroup"
 )
 
+// RoomInitFunc creates the metadata for a new room. Returning nil metadata
+// without an error fails the room's creation with ErrNilMetadata.
 type RoomInitFunc[RoomMetadata any] func(ctx context.Context, id string) (metadata *RoomMetadata, err error)
 
+// RoomHandlerFunc handles a room's events. Its goroutine has started by the
+// time the room is returned by GetOrCreateRoom, but the handler may not have
+// been called or begun reading yet. Events emitted until it reads them from
+// Room.Events are buffered (up to 1024 of them), and by default the room
+// closes if the buffer overflows (see WithEventOverflow), so the handler
+// should do any slow setup after it starts reading.
 type RoomHandlerFunc[RoomMetadata, ClientMetadata, DataType any] func(ctx context.Context, room *Room[RoomMetadata, ClientMetadata, DataType])
 
 type Room[RoomMetadata, ClientMetadata, DataType any] struct {
-	initGroup errgroup.Group
+	// initDone is closed once init has finished, after which initErr is set.
+	initDone chan struct{}
+	initErr  error
+	waiters  atomic.Int32
+	// handlerDone is closed once the handler has exited for good, or right
+	// after init if it failed and the handler never ran.
+	handlerDone chan struct{}
 
 	id           string
-	metadata     *RoomMetadata
-	clients      map[*Client[ClientMetadata, DataType]]struct{}
+	idMu         sync.RWMutex
+	name         string
+	hotel        *Hotel[RoomMetadata, ClientMetadata, DataType]
+	init         RoomInitFunc[RoomMetadata]
+	metadata     atomic.Pointer[RoomMetadata]
+	metadataMu   sync.Mutex
+	codec        Codec[DataType]
+	clientOpts   clientOptions[DataType]
+	clients      map[*Client[ClientMetadata, DataType]]uint64 // Client to join sequence.
+	joinSeq      uint64
+	clientList   []*Client[ClientMetadata, DataType] // Cached by Clients, reset when clients changes.
+	users        map[string]int                      // User ID to number of clients.
 	mu           sync.RWMutex
 	ctx          context.Context
 	cancel       context.CancelFunc
+	closed       atomic.Bool
+	closedAt     atomic.Int64
+	generation   atomic.Uint64
+	createdAt    time.Time
+	emptySince   time.Time
+	lastActivity atomic.Int64
+	eventCount   atomic.Uint64
+	lastError    atomic.Pointer[RoomError]
+	history      atomic.Pointer[history[DataType]]
+	onClose      []func(room *Room[RoomMetadata, ClientMetadata, DataType])
+	onSendError  func(client *Client[ClientMetadata, DataType], err error) (remove bool)
 	eventsCh     chan Event[ClientMetadata, DataType]
-	closeTimer   *time.Timer
+	eventsMu     sync.RWMutex
+	eventsClosed bool
+	diagCh       chan Event[ClientMetadata, DataType]
+	subscribers  subscribers[ClientMetadata, DataType]
+	broadcastMu  sync.Mutex
+	broadcastLim *tokenBucket
+	closeTimer   Timer
 	closeTimerMu sync.Mutex
+	canAutoClose func(room *Room[RoomMetadata, ClientMetadata, DataType]) bool
+	tickersMu    sync.Mutex
+	tickers      map[string]*roomTicker
+	admitMu      sync.Mutex // Serializes admissions, and guards admit.
+	admit        AdmissionFunc[RoomMetadata, ClientMetadata, DataType]
+
+	// maxClients is how many clients the room can hold, or zero for no
+	// limit. It's guarded by mu.
+	maxClients int
+
+	// droppedEvents counts the events dropped since the last EventDropped
+	// (see EventOverflowDrop).
+	droppedEvents atomic.Uint64
+
+	// closeDelay is the auto-close delay, and closeScheduledAt is when the
+	// delay started counting, or zero if the room isn't waiting to close.
+	// Both are guarded by closeTimerMu.
+	closeDelay       time.Duration
+	closeScheduledAt time.Time
+}
+
+// AdmissionFunc decides whether a client may join a room, given the client's
+// metadata. The metadata is a copy, which it may modify, e.g. to make a late
+// joiner a spectator; if the client is admitted, the copy replaces its
+// metadata. If it returns false without an error, the join fails with
+// ErrAdmissionDenied.
+type AdmissionFunc[RoomMetadata, ClientMetadata, DataType any] func(room *Room[RoomMetadata, ClientMetadata, DataType], metadata *ClientMetadata) (admit bool, err error)
+
+// RoomCodecSelector can be implemented by room metadata to pick a codec for a
+// specific room, e.g. for tenants using different wire formats. If the room's
+// metadata doesn't implement it, or returns nil, the hotel's codec is used.
+type RoomCodecSelector[DataType any] interface {
+	RoomCodec() Codec[DataType]
 }
 
-// TODO: This should be configurable on either a per-room or global basis.
+// DefaultAutoCloseDelay is how long an empty room stays open unless
+// configured otherwise with WithAutoCloseDelay or Room.SetAutoCloseDelay.
 const DefaultAutoCloseDelay = 2 * time.Minute
 
-func newRoom[RoomMetadata, ClientMetadata, DataType any](id string, init RoomInitFunc[RoomMetadata], handler RoomHandlerFunc[RoomMetadata, ClientMetadata, DataType]) *Room[RoomMetadata, ClientMetadata, DataType] {
-	ctx, cancel := context.WithCancel(context.Background())
+func newRoom[RoomMetadata, ClientMetadata, DataType any](h *Hotel[RoomMetadata, ClientMetadata, DataType], id string, init RoomInitFunc[RoomMetadata], handler RoomHandlerFunc[RoomMetadata, ClientMetadata, DataType]) *Room[RoomMetadata, ClientMetadata, DataType] {
+	ctx := context.Background()
+	if h.opts.dependencies != nil {
+		ctx = context.WithValue(ctx, dependenciesKey{}, h.opts.dependencies)
+	}
+	ctx, cancel := context.WithCancel(ctx)
 	eventsCh := make(chan Event[ClientMetadata, DataType], 1024)
 	room := &Room[RoomMetadata, ClientMetadata, DataType]{
-		id:       id,
-		clients:  make(map[*Client[ClientMetadata, DataType]]struct{}),
-		ctx:      ctx,
-		cancel:   cancel,
-		eventsCh: eventsCh,
+		initDone:    make(chan struct{}),
+		handlerDone: make(chan struct{}),
+		id:          id,
+		init:        init,
+		hotel:       h,
+		codec:       h.codec,
+		clientOpts: clientOptions[DataType]{
+			codec:        h.codec,
+			trackLatency: h.opts.trackLatency,
+			evictAfter:   h.opts.evictAfter,
+			direct:       h.opts.directDelivery,
+			bufferSize:   DefaultClientBufferSize,
+			overflow:     h.opts.overflow,
+			logger:       h.logger,
+			dataRate:     h.opts.clientDataRate,
+			dataBurst:    h.opts.clientDataBurst,
+			clock:        h.clock,
+		},
+		clients:   make(map[*Client[ClientMetadata, DataType]]uint64),
+		ctx:       ctx,
+		cancel:    cancel,
+		eventsCh:  eventsCh,
+		createdAt: time.Now(),
+	}
+	if h.opts.clientBufferSize > 0 {
+		room.clientOpts.bufferSize = h.opts.clientBufferSize
+	}
+	room.maxClients = h.opts.maxClients
+	room.closeDelay = DefaultAutoCloseDelay
+	if h.opts.autoCloseDelaySet {
+		room.closeDelay = h.opts.autoCloseDelay
 	}
-	room.initGroup.Go(func() error {
+	room.lastActivity.Store(room.createdAt.UnixNano())
+	if h.opts.historySize > 0 {
+		room.history.Store(newHistory[DataType](h.opts.historySize))
+	}
+	if h.opts.diagnosticsBuffer > 0 {
+		room.diagCh = make(chan Event[ClientMetadata, DataType], h.opts.diagnosticsBuffer)
+	}
+	go func() {
+		var err error
+		start := time.Now()
 		defer func() {
-			if err := recover(); err != nil {
-				const size = 64 << 10
-				buf := make([]byte, size)
-				buf = buf[:runtime.Stack(buf, false)]
-				log.Printf("Room %s init panicked: %v\n%s", room.id, err, buf)
+			duration := time.Since(start)
+			if err != nil {
+				room.log().Warn("room init failed", "error", err, "duration", duration)
+				// Release the room's context; the hotel forgets the room.
 				room.Close()
+				close(room.handlerDone)
+			} else {
+				room.log().Debug("room initialized", "duration", duration)
 			}
+			h.recordInit(id, duration, err)
+			room.initErr = err
+			close(room.initDone)
 		}()
 
-		metadata, err := init(ctx, id)
+		initCtx := ctx
+		if h.opts.initTimeout > 0 {
+			var cancel context.CancelFunc
+			initCtx, cancel = context.WithTimeout(ctx, h.opts.initTimeout)
+			defer cancel()
+		}
+		metadata, err := room.callInit(initCtx)
 		if err != nil {
-			return err
+			return
+		}
+		if metadata == nil {
+			err = fmt.Errorf("room init returned %w", ErrNilMetadata)
+			return
+		}
+		// The room may have been closed while init was finishing.
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		room.metadata.Store(metadata)
+		if selector, ok := any(metadata).(RoomCodecSelector[DataType]); ok {
+			if codec := selector.RoomCodec(); codec != nil {
+				room.codec = codec
+				room.clientOpts.codec = codec
+			}
 		}
-		// TODO: We should return as soon as the context is cancelled, rather
-		// than waiting on the init function to return.
-		if err := ctx.Err(); err != nil {
-			return err
+		// Only count a room as idle once it's ready for clients.
+		room.mu.Lock()
+		if len(room.clients) == 0 {
+			room.emptySince = h.clock.Now()
 		}
-		room.metadata = metadata
+		room.mu.Unlock()
 
-		go func() {
-			defer func() {
-				if err := recover(); err != nil {
-					const size = 64 << 10
-					buf := make([]byte, size)
-					buf = buf[:runtime.Stack(buf, false)]
-					log.Printf("Room %s handler panicked: %v\n%s", room.id, err, buf)
-				}
-				room.Close()
-			}()
-			handler(ctx, room)
-		}()
-		return nil
-	})
+		// Callers get the room as soon as init is done, so make sure the
+		// handler's goroutine is running by then rather than leaving events
+		// to pile up until it gets scheduled. The handler itself may still
+		// be about to be called; the events channel buffers events until it
+		// reads them.
+		started := make(chan struct{})
+		go room.runHandler(handler, h.opts.handlerRestarts, h.opts.handlerBackoff, started)
+		<-started
+	}()
 	return room
 }
 
+// callInit calls the room's init function, but returns early with the
+// context's error if ctx is done first, leaving init to finish in the
+// background. A panic in init is returned as an error.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) callInit(ctx context.Context) (*RoomMetadata, error) {
+	type result struct {
+		metadata *RoomMetadata
+		err      error
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				const size = 64 << 10
+				buf := make([]byte, size)
+				buf = buf[:runtime.Stack(buf, false)]
+				r.log().Error("room init panicked", "panic", p, "stack", string(buf))
+				err := fmt.Errorf("room init panicked: %v", p)
+				r.recordError(ErrorPanic, err)
+				done <- result{err: err}
+			}
+		}()
+		metadata, err := r.init(ctx, r.ID())
+		done <- result{metadata, err}
+	}()
+	select {
+	case res := <-done:
+		return res.metadata, res.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("room init: %w", context.Cause(ctx))
+	}
+}
+
+// waitInit waits for the room's init to finish and returns its error. If
+// maxWaiters is positive and that many callers are already waiting for init,
+// ErrTooManyWaiters is returned immediately instead.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) waitInit(ctx context.Context, maxWaiters int) error {
+	select {
+	case <-r.initDone:
+		return r.initErr
+	default:
+	}
+	if n := r.waiters.Add(1); maxWaiters > 0 && int(n) > maxWaiters {
+		r.waiters.Add(-1)
+		return ErrTooManyWaiters
+	}
+	defer r.waiters.Add(-1)
+	select {
+	case <-r.initDone:
+		return r.initErr
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// live reports whether the room's init has succeeded and the room is not yet
+// closed.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) live() bool {
+	select {
+	case <-r.initDone:
+		return r.initErr == nil && r.ctx.Err() == nil
+	default:
+		return false
+	}
+}
+
+// runHandler runs the handler until it returns, restarting it after a panic
+// up to maxRestarts times, then closes the room. The started channel is closed
+// right before the handler is first called, which means the goroutine is
+// running but not that the handler has started reading events.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) runHandler(handler RoomHandlerFunc[RoomMetadata, ClientMetadata, DataType], maxRestarts int, backoff func(n int) time.Duration, started chan<- struct{}) {
+	defer close(r.handlerDone)
+	defer r.Close()
+	r.log().Debug("room handler started")
+	defer func() { r.log().Debug("room handler exited") }()
+	close(started)
+	for n := 1; r.callHandler(handler) && n <= maxRestarts; n++ {
+		if backoff != nil {
+			select {
+			case <-r.ctx.Done():
+				return
+			case <-time.After(backoff(n)):
+			}
+		}
+		r.log().Warn("restarting room handler", "restart", n, "max_restarts", maxRestarts)
+		// Events that were queued for the crashed handler remain in the
+		// channel, so the restarted handler resumes where the previous one
+		// left off and sees this once it has caught up with them.
+		r.Emit(Event[ClientMetadata, DataType]{
+			Type: EventHandlerRestarted,
+		})
+	}
+}
+
+// callHandler runs the handler and reports whether it panicked.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) callHandler(handler RoomHandlerFunc[RoomMetadata, ClientMetadata, DataType]) (panicked bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			const size = 64 << 10
+			buf := make([]byte, size)
+			buf = buf[:runtime.Stack(buf, false)]
+			r.log().Error("room handler panicked", "panic", err, "stack", string(buf))
+			r.recordError(ErrorPanic, fmt.Errorf("room handler panicked: %v", err))
+			panicked = true
+		}
+	}()
+	handler(r.ctx, r)
+	return false
+}
+
+// log returns the hotel's logger with the room's ID attached.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) log() *slog.Logger {
+	return r.hotel.logger.With("room.id", r.ID())
+}
+
 func (r *Room[RoomMetadata, ClientMetadata, DataType]) ID() string {
+	r.idMu.RLock()
+	defer r.idMu.RUnlock()
 	return r.id
 }
 
+// Name returns the room's display name, which defaults to its ID.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) Name() string {
+	r.idMu.RLock()
+	defer r.idMu.RUnlock()
+	if r.name == "" {
+		return r.id
+	}
+	return r.name
+}
+
+// SetName sets the room's display name, e.g. for admin tools and lobby
+// listings. Unlike the ID, it doesn't need to be unique. Setting it to an
+// empty string reverts to using the ID.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) SetName(name string) {
+	r.idMu.Lock()
+	r.name = name
+	r.idMu.Unlock()
+}
+
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) setID(id string) {
+	r.idMu.Lock()
+	r.id = id
+	r.idMu.Unlock()
+}
+
+// Events returns the channel of events for the room's handler. It's closed
+// once the room has closed and emitted its final events, such as the leaves of
+// its last clients, so a handler can simply range over it.
 func (r *Room[RoomMetadata, ClientMetadata, DataType]) Events() <-chan Event[ClientMetadata, DataType] {
 	return r.eventsCh
 }
 
+// Codec returns the codec that the room uses to convert data to and from
+// bytes, which transports should use for this room. It's nil if neither the
+// room nor the hotel has a codec.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) Codec() Codec[DataType] {
+	return r.codec
+}
+
+// Metadata returns the room's metadata. Mutating it directly is only safe if
+// nothing else accesses it concurrently; once anything changes it with
+// UpdateMetadata or UpdateAndBroadcast, use those for every mutation.
 func (r *Room[RoomMetadata, ClientMetadata, DataType]) Metadata() *RoomMetadata {
-	return r.metadata
+	return r.metadata.Load()
+}
+
+// UpdateMetadata runs update on the room's metadata in place, under the lock
+// that also serializes UpdateAndBroadcast and Reinitialize, and then emits an
+// EventRoomMetadataChanged so the handler can notify clients. Code that reads
+// the metadata while it may be updated should do so from within update (or
+// UpdateAndBroadcast) too. It does nothing if the room has no metadata yet
+// because its init hasn't finished.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) UpdateMetadata(update func(metadata *RoomMetadata)) {
+	r.metadataMu.Lock()
+	defer r.metadataMu.Unlock()
+	metadata := r.metadata.Load()
+	if metadata == nil {
+		return
+	}
+	update(metadata)
+	r.Emit(Event[ClientMetadata, DataType]{
+		Type: EventRoomMetadataChanged,
+	})
 }
 
+// UpdateAndBroadcast runs update on the room's metadata and, if it returns
+// true, broadcasts the data it returned. Like in UpdateMetadata, the update
+// happens in place and is skipped if the room has no metadata yet. Concurrent
+// calls are serialized for both the update and the broadcast, so clients see
+// broadcasts in the same order as the updates they describe. Like
+// BroadcastSync, it returns
+// ErrBroadcastRateLimited if the room is over its broadcast rate limit, in
+// which case update isn't run, and otherwise joins the errors of all failed
+// sends. A call counts against the rate limit even if update returns false.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) UpdateAndBroadcast(update func(metadata *RoomMetadata) (DataType, bool)) error {
+	r.metadataMu.Lock()
+	defer r.metadataMu.Unlock()
+	// Checked first, so that the metadata is never changed without clients
+	// being told.
+	if err := r.allowBroadcast(); err != nil {
+		return err
+	}
+	metadata := r.metadata.Load()
+	if metadata == nil {
+		return nil
+	}
+	data, ok := update(metadata)
+	if !ok {
+		return nil
+	}
+	r.recordHistory(data)
+	_, err := r.broadcast(nil, queuedItem[DataType]{data: data})
+	return err
+}
+
+// Reinitialize runs the room's init function again and swaps in the metadata
+// it returns, e.g. to refresh expensive state, without disturbing the room's
+// clients or handler. On success an EventReinitialized is emitted so that the
+// handler can broadcast the new state. If init fails, the old metadata is
+// kept and the error is returned. The room's codec is not changed.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) Reinitialize(ctx context.Context) (err error) {
+	if deps := r.hotel.opts.dependencies; deps != nil {
+		ctx = context.WithValue(ctx, dependenciesKey{}, deps)
+	}
+	r.metadataMu.Lock()
+	defer r.metadataMu.Unlock()
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("room init panicked: %v", p)
+			r.recordError(ErrorPanic, err)
+		}
+	}()
+	metadata, err := r.init(ctx, r.ID())
+	if err != nil {
+		return err
+	}
+	if metadata == nil {
+		return fmt.Errorf("room init returned %w", ErrNilMetadata)
+	}
+	r.metadata.Store(metadata)
+	r.Emit(Event[ClientMetadata, DataType]{
+		Type: EventReinitialized,
+	})
+	return nil
+}
+
+// NewClient creates a client with the given metadata, which must not be nil,
+// and adds it to the room. If the room won't take the client, it's closed
+// with ReasonRejected, or ReasonRoomClosed if the room has closed.
 func (r *Room[RoomMetadata, ClientMetadata, DataType]) NewClient(metadata *ClientMetadata) (*Client[ClientMetadata, DataType], error) {
+	return r.newRoomClient("", metadata)
+}
+
+// newRoomClient creates a client for this room, belonging to the user with
+// the given ID unless it's empty, and adds it to the room.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) newRoomClient(userID string, metadata *ClientMetadata) (*Client[ClientMetadata, DataType], error) {
+	if metadata == nil {
+		return nil, fmt.Errorf("cannot create client: %w", ErrNilMetadata)
+	}
+	client := newClient[ClientMetadata, DataType](metadata, r.clientOpts)
+	client.userID = userID
+	if err := r.addNewClient(client); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// addNewClient adds a client created for this room, closing it if the room
+// won't take it.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) addNewClient(client *Client[ClientMetadata, DataType]) error {
+	err := r.addClient(client)
+	if err != nil {
+		reason := ReasonRejected
+		if errors.Is(err, ErrRoomClosed) {
+			reason = ReasonRoomClosed
+		}
+		client.CloseWithReason(reason)
+	}
+	return err
+}
+
+// AddClient adds a client that was created in another room to this room as
+// well, so that it's a member of both. The client keeps the codec of the room
+// it was created in. A client in several rooms is only closed once it has
+// left all of them, or when it's closed directly.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) AddClient(client *Client[ClientMetadata, DataType]) error {
+	if client.ctx.Err() != nil {
+		return errors.New("cannot add client: client is closed")
+	}
+	r.mu.RLock()
+	_, exists := r.clients[client]
+	r.mu.RUnlock()
+	if exists {
+		return errors.New("cannot add client: client is already in the room")
+	}
+	return r.addClient(client)
+}
+
+// addClient makes the client a member of the room and emits its join.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) addClient(client *Client[ClientMetadata, DataType]) error {
+	r.admitMu.Lock()
+	defer r.admitMu.Unlock()
+	if r.admit != nil && r.ctx.Err() == nil {
+		// The hook gets a copy, since the client may be in other rooms whose
+		// goroutines read its metadata concurrently.
+		current := client.Metadata()
+		metadata := *current
+		admit, err := r.admit(r, &metadata)
+		if err != nil {
+			return fmt.Errorf("cannot add client: %w", err)
+		}
+		if !admit {
+			return fmt.Errorf("cannot add client: %w", ErrAdmissionDenied)
+		}
+		// Unless the metadata was swapped meanwhile, publish the hook's copy.
+		client.metadata.CompareAndSwap(current, &metadata)
+	}
 	r.mu.Lock()
 	select {
 	case <-r.ctx.Done():
 		r.mu.Unlock()
-		return nil, errors.New("cannot add client: room is closed")
+		return fmt.Errorf("cannot add client: %w", ErrRoomClosed)
 	default:
+		if r.maxClients > 0 && len(r.clients) >= r.maxClients {
+			r.mu.Unlock()
+			return fmt.Errorf("cannot add client: %w", ErrRoomFull)
+		}
 		// Cancel any pending close timer
 		r.cancelCloseTimer()
 
-		client := newClient[ClientMetadata, DataType](metadata)
-		newClients := make(map[*Client[ClientMetadata, DataType]]struct{}, len(r.clients)+1)
-		for c := range r.clients {
-			newClients[c] = struct{}{}
+		client.addRoom(r)
+		newClients := make(map[*Client[ClientMetadata, DataType]]uint64, len(r.clients)+1)
+		for c, seq := range r.clients {
+			newClients[c] = seq
 		}
-		newClients[client] = struct{}{}
+		r.joinSeq++
+		newClients[client] = r.joinSeq
+		wasEmpty := len(r.clients) == 0
 		r.clients = newClients
+		r.clientList = nil
+		r.emptySince = time.Time{}
+		userJoined := r.addUserClient(client)
 		r.mu.Unlock()
+		if wasEmpty {
+			r.Emit(Event[ClientMetadata, DataType]{
+				Type: EventOccupied,
+			})
+		}
 		r.Emit(Event[ClientMetadata, DataType]{
 			Type:   EventJoin,
 			Client: client,
 		})
-		return client, nil
+		if metrics := r.hotel.metrics; metrics != nil {
+			metrics.ClientJoined(r.ID())
+		}
+		if userJoined {
+			r.Emit(Event[ClientMetadata, DataType]{
+				Type:   EventUserJoined,
+				Client: client,
+			})
+		}
+		r.hotel.notifyLobby(EventRoomOccupancyChanged, r)
+		return nil
 	}
 }
 
+// RemoveClient removes the client from the room and closes it, unless it's
+// still in other rooms. Data sent to the client before this is either
+// received by its consumer before the receive channel closes, or discarded;
+// anything still buffered when the client closes is discarded. Use
+// RemoveClientGraceful to deliver it first.
 func (r *Room[RoomMetadata, ClientMetadata, DataType]) RemoveClient(client *Client[ClientMetadata, DataType]) error {
+	return r.removeClient(client, ReasonRemoved, true)
+}
+
+// DisconnectIdle removes every client that hasn't sent data to any room (see
+// Client.LastActivity) for longer than threshold, like RemoveClient but with
+// the given reason, and returns how many clients were removed.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) DisconnectIdle(threshold time.Duration, reason LeaveReason) int {
+	n := 0
+	for _, client := range r.Clients() {
+		if r.hotel.clock.Now().Sub(client.LastActivity()) <= threshold {
+			continue
+		}
+		if r.removeClient(client, reason, true) == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// RemoveClientGraceful removes the client from the room like RemoveClient,
+// but waits up to timeout for the consumer to receive everything that was
+// sent to the client before closing it. The client gets no new data from the
+// room once it's removed. If the timeout passes first, the remaining data is
+// discarded and context.DeadlineExceeded is returned.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) RemoveClientGraceful(client *Client[ClientMetadata, DataType], timeout time.Duration) error {
+	if err := r.removeClient(client, ReasonRemoved, false); err != nil {
+		return err
+	}
+	if len(client.roomList()) > 0 {
+		// The client stays open for its other rooms.
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := flushClients(ctx, []*Client[ClientMetadata, DataType]{client})
+	client.CloseWithReason(ReasonRemoved)
+	return err
+}
+
+// removeClient takes the client out of the room and emits its leave. If
+// closeClient is true, the client is also closed with reason unless it's
+// still in other rooms.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) removeClient(client *Client[ClientMetadata, DataType], reason LeaveReason, closeClient bool) error {
 	r.mu.Lock()
 	if _, exists := r.clients[client]; !exists {
 		r.mu.Unlock()
 		return fmt.Errorf("client not found")
 	}
-	newClients := make(map[*Client[ClientMetadata, DataType]]struct{}, len(r.clients)-1)
-	for c := range r.clients {
+	newClients := make(map[*Client[ClientMetadata, DataType]]uint64, len(r.clients)-1)
+	for c, seq := range r.clients {
 		if c != client {
-			newClients[c] = struct{}{}
+			newClients[c] = seq
 		}
 	}
 	r.clients = newClients
+	r.clientList = nil
 	isEmpty := len(newClients) == 0
+	if isEmpty {
+		r.emptySince = r.hotel.clock.Now()
+	}
+	userLeft := r.removeUserClient(client)
 	r.mu.Unlock()
-
+	if !client.removeRoom(r) && closeClient {
+		client.CloseWithReason(reason)
+	}
+	// The client may have been closed for another reason already.
+	if closeReason, closed := client.CloseReason(); closed {
+		reason = closeReason
+	}
 	r.Emit(Event[ClientMetadata, DataType]{
 		Type:   EventLeave,
 		Client: client,
+		Reason: reason,
 	})
-	client.Close()
+	if metrics := r.hotel.metrics; metrics != nil {
+		metrics.ClientLeft(r.ID(), reason)
+	}
+	if userLeft {
+		r.Emit(Event[ClientMetadata, DataType]{
+			Type:   EventUserLeft,
+			Client: client,
+			Reason: reason,
+		})
+	}
+	r.hotel.notifyLobby(EventRoomOccupancyChanged, r)
 
 	// Schedule room closure if empty
 	if isEmpty {
+		r.Emit(Event[ClientMetadata, DataType]{
+			Type: EventEmpty,
+		})
 		r.scheduleClose()
 	}
 	return nil
 }
 
+// TransferAll moves every client in the room to dest, e.g. to evacuate a room
+// whose backend is going away, and returns how many clients were moved. Each
+// client keeps its connection and any data buffered for it, and joins dest
+// before leaving this room, so it's never without a room. Clients keep the
+// codec of the room they were created in. Transports that hold on to a
+// client's room should look it up again with RoomOf. If dest can't take a
+// client, the remaining clients stay in this room and an error is returned.
+// This room isn't closed, but will auto-close like any empty room.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) TransferAll(dest *Room[RoomMetadata, ClientMetadata, DataType]) (moved int, err error) {
+	if dest == r {
+		return 0, errors.New("cannot transfer clients to the same room")
+	}
+	for _, client := range r.Clients() {
+		if client.ctx.Err() != nil {
+			continue
+		}
+		if err := dest.addClient(client); err != nil {
+			return moved, fmt.Errorf("transferred %d clients: %w", moved, err)
+		}
+		// The client may have left or closed concurrently, in which case it
+		// has already been removed.
+		r.removeClient(client, ReasonTransferred, false)
+		moved++
+	}
+	return moved, nil
+}
+
+// EventBacklog returns the number of events waiting to be read by the
+// handler.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) EventBacklog() int {
+	return len(r.eventsCh)
+}
+
+// DiagnosticEvents returns a channel that mirrors every event emitted in the
+// room, for debugging without consuming the handler's events. It's nil unless
+// the hotel was created with WithEventDiagnostics. Events are dropped from
+// this channel rather than slowing down the room if it isn't read quickly
+// enough.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) DiagnosticEvents() <-chan Event[ClientMetadata, DataType] {
+	return r.diagCh
+}
+
 func (r *Room[RoomMetadata, ClientMetadata, DataType]) Emit(event Event[ClientMetadata, DataType]) {
+	event.Emitted = time.Now()
+	r.mirror(event)
+	// Sends happen under a read lock and never block, so that the room can
+	// close the channel once it has emitted its last events.
+	r.eventsMu.RLock()
+	if r.eventsClosed {
+		r.eventsMu.RUnlock()
+		return
+	}
 	select {
 	case r.eventsCh <- event:
+		r.eventsMu.RUnlock()
+		r.observeDepth()
+		return
 	default:
-		log.Printf("Warning: Room %s events channel is full. Cannot send %s. Closing room.", r.id, event.Type)
+	}
+	policy := r.hotel.opts.eventOverflow
+	if policy == EventOverflowBlock {
+		// The wait is bounded because the emitter may be the handler itself,
+		// which would otherwise wait forever. Closing the room cancels its
+		// context before taking the write lock, so this never holds up the
+		// close.
+		timedOut := make(chan struct{})
+		timer := r.hotel.clock.AfterFunc(eventBlockTimeout, func() { close(timedOut) })
+		select {
+		case r.eventsCh <- event:
+			timer.Stop()
+			r.eventsMu.RUnlock()
+			r.observeDepth()
+			return
+		case <-timedOut:
+		case <-r.ctx.Done():
+			timer.Stop()
+		}
+	}
+	r.eventsMu.RUnlock()
+	if metrics := r.hotel.metrics; metrics != nil {
+		metrics.EventDropped(r.ID(), event.Type)
+	}
+	if policy == EventOverflowClose {
+		r.log().Warn("room events channel is full, closing room", "event", event.Type)
+		r.recordError(ErrorOverflow, fmt.Errorf("events channel full, cannot send %s", event.Type))
 		r.Close()
+		return
 	}
+	if r.droppedEvents.Add(1) == 1 {
+		r.log().Warn("room events channel is full, dropping events", "event", event.Type)
+		r.recordError(ErrorOverflow, fmt.Errorf("events channel full, dropped %s", event.Type))
+		go r.reportDropped()
+	}
+}
+
+// mirror counts an event and copies it to the diagnostics channel and the
+// subscribers.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) mirror(event Event[ClientMetadata, DataType]) {
+	r.eventCount.Add(1)
+	r.lastActivity.Store(event.Emitted.UnixNano())
+	if r.diagCh != nil {
+		select {
+		case r.diagCh <- event:
+		default:
+		}
+	}
+	r.subscribers.send(event)
 }
 
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) observeDepth() {
+	if metrics := r.hotel.metrics; metrics != nil {
+		metrics.EventChannelDepth(r.ID(), len(r.eventsCh))
+	}
+}
+
+// reportDropped waits for the handler to catch up after events were dropped,
+// then tells it how many with an EventDropped. Events dropped while it waits
+// are reported in a following EventDropped. Only one runs at a time: it's
+// started by the first drop and returns once every drop has been reported.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) reportDropped() {
+	for {
+		n := r.droppedEvents.Load()
+		event := Event[ClientMetadata, DataType]{Type: EventDropped, Dropped: n, Emitted: time.Now()}
+		r.mirror(event)
+		r.eventsMu.RLock()
+		if r.eventsClosed {
+			r.eventsMu.RUnlock()
+			return
+		}
+		select {
+		case r.eventsCh <- event:
+		case <-r.ctx.Done():
+			r.eventsMu.RUnlock()
+			return
+		}
+		r.eventsMu.RUnlock()
+		r.observeDepth()
+		if r.droppedEvents.Add(-n) == 0 {
+			return
+		}
+	}
+}
+
+// HandleClientData passes data received from the client to the handler as an
+// EventCustom. It may be called concurrently for the same client, e.g. by
+// several transports (a WebSocket and HTTP requests) feeding one logical
+// client: data from each caller reaches the handler in the order that caller
+// passed it in, interleaved with the other callers' data in the order the
+// calls happened. Data over the client's rate limit (see WithClientRateLimit)
+// is rejected with ErrClientRateLimited.
 func (r *Room[RoomMetadata, ClientMetadata, DataType]) HandleClientData(client *Client[ClientMetadata, DataType], data DataType) error {
 	r.mu.RLock()
 	_, exists := r.clients[client]
 	r.mu.RUnlock()
+	// The room's context is cancelled before its clients are cleared, so this
+	// also catches clients that were only missing because the room closed.
+	if r.ctx.Err() != nil {
+		return ErrRoomClosed
+	}
+	if !exists {
+		return fmt.Errorf("client not found")
+	}
+	if !client.allowData() {
+		return ErrClientRateLimited
+	}
+	client.recordReceived()
+	r.Emit(Event[ClientMetadata, DataType]{
+		Type:   EventCustom,
+		Client: client,
+		Data:   data,
+	})
+	return nil
+}
+
+// HandleClientBytes decodes inbound bytes with the room's codec and passes the
+// result on like HandleClientData. If decoding fails, an EventDecodeError is
+// emitted so that the handler can apply policy (e.g. disconnect abusive
+// clients), and the error is returned. The same happens without decoding if
+// the bytes exceed the hotel's maximum message size, with ErrMessageTooLarge,
+// or while decoding if the codec is a LimitedDecoder and the decoded frame
+// exceeds it.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) HandleClientBytes(client *Client[ClientMetadata, DataType], raw []byte) error {
+	if r.codec == nil {
+		return errors.New("cannot decode bytes: room has no codec")
+	}
+	r.mu.RLock()
+	_, exists := r.clients[client]
+	r.mu.RUnlock()
+	if r.ctx.Err() != nil {
+		return ErrRoomClosed
+	}
 	if !exists {
 		return fmt.Errorf("client not found")
 	}
+	if !client.allowData() {
+		return ErrClientRateLimited
+	}
+	client.recordReceived()
+	if limit := r.hotel.opts.maxMessageSize; limit > 0 && len(raw) > limit {
+		// The raw bytes are left out of the event to avoid holding on to
+		// them.
+		r.Emit(Event[ClientMetadata, DataType]{
+			Type:   EventDecodeError,
+			Client: client,
+			Err:    ErrMessageTooLarge,
+		})
+		return ErrMessageTooLarge
+	}
+	var data DataType
+	var err error
+	if decoder, ok := r.codec.(LimitedDecoder[DataType]); ok {
+		data, err = decoder.DecodeLimit(raw, r.hotel.opts.maxMessageSize)
+	} else {
+		data, err = r.codec.Decode(raw)
+	}
+	if err != nil {
+		r.Emit(Event[ClientMetadata, DataType]{
+			Type:   EventDecodeError,
+			Client: client,
+			Raw:    raw,
+			Err:    err,
+		})
+		return fmt.Errorf("failed to decode data: %w", err)
+	}
 	r.Emit(Event[ClientMetadata, DataType]{
 		Type:   EventCustom,
 		Client: client,
@@ -174,58 +920,568 @@ func (r *Room[RoomMetadata, ClientMetadata, DataType]) HandleClientData(client *
 }
 
 func (r *Room[RoomMetadata, ClientMetadata, DataType]) SendToClient(client *Client[ClientMetadata, DataType], data DataType) error {
+	return r.sendToClient(client, queuedItem[DataType]{data: data})
+}
+
+// SendToClientCtx is like SendToClient, but if the client's buffer is full it
+// waits for room instead of dropping the data, until ctx is done, and it never
+// closes the client. Use it for data that must be delivered, such as control
+// messages.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) SendToClientCtx(ctx context.Context, client *Client[ClientMetadata, DataType], data DataType) error {
 	r.mu.RLock()
 	_, exists := r.clients[client]
 	r.mu.RUnlock()
 	if !exists {
 		return fmt.Errorf("client not found")
 	}
-	if err := client.send(data); err != nil {
-		r.RemoveClient(client)
+	if err := client.enqueueWait(ctx, queuedItem[DataType]{data: data}); err != nil {
+		return fmt.Errorf("failed to send data: %w", err)
+	}
+	return nil
+}
+
+// RespondTo sends response to the client that caused event, as a reply to a
+// request. To let clients match replies with their requests when several are
+// in flight, the convention is for request messages to include a request ID
+// and for the handler to copy that ID into the response before calling this.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) RespondTo(event Event[ClientMetadata, DataType], response DataType) error {
+	if event.Client == nil {
+		return fmt.Errorf("cannot respond to %s: event has no client", event.Type)
+	}
+	return r.SendToClient(event.Client, response)
+}
+
+// SendToClientBytes queues already encoded bytes for the client, avoiding a
+// decode and re-encode round trip when relaying data. The room must have a
+// codec so that clients reading through Receive can still decode the bytes.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) SendToClientBytes(client *Client[ClientMetadata, DataType], raw []byte) error {
+	if r.codec == nil {
+		return errors.New("cannot send bytes: room has no codec")
+	}
+	if raw == nil {
+		raw = []byte{}
+	}
+	return r.sendToClient(client, queuedItem[DataType]{raw: raw})
+}
+
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) sendToClient(client *Client[ClientMetadata, DataType], item queuedItem[DataType]) error {
+	r.mu.RLock()
+	_, exists := r.clients[client]
+	r.mu.RUnlock()
+	if !exists {
+		return fmt.Errorf("client not found")
+	}
+	if err := client.enqueue(item); err != nil {
+		if !errors.Is(err, ErrClientDrained) {
+			r.recordError(ErrorSendFailure, fmt.Errorf("client %s: %w", client.ID(), err))
+		}
+		// Drained clients are intentionally not receiving data, and dropped
+		// data doesn't mean the client was evicted, so they're left in the
+		// room.
+		if !errors.Is(err, ErrClientDrained) && !errors.Is(err, ErrDataDropped) {
+			r.removeFailedClient(client, err)
+		}
 		return fmt.Errorf("failed to send data: %w", err)
 	}
 	return nil
 }
 
 func (r *Room[RoomMetadata, ClientMetadata, DataType]) Broadcast(data DataType) {
+	if r.broadcastLimited() {
+		return
+	}
+	r.recordHistory(data)
+	r.broadcast(nil, queuedItem[DataType]{data: data})
+}
+
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) BroadcastExcept(except *Client[ClientMetadata, DataType], data DataType) {
+	if r.broadcastLimited() {
+		return
+	}
+	r.recordHistory(data)
+	r.broadcast(func(c *Client[ClientMetadata, DataType]) bool {
+		return c != except
+	}, queuedItem[DataType]{data: data})
+}
+
+// SetBroadcastRateLimit limits how often data can be broadcast in the room to
+// rate broadcasts per second, with bursts of up to burst broadcasts, as a
+// safety valve against handlers flooding their clients. Broadcasts over the
+// limit are not sent: methods that return an error, such as BroadcastSync,
+// return ErrBroadcastRateLimited, methods that return per-client results,
+// such as BroadcastResult, return a single result with a nil Client and
+// ErrBroadcastRateLimited, and the others drop the data and record the error
+// as the room's LastError. This includes SendToMatching, which then returns
+// zero, and BroadcastChunked, which counts as a single broadcast. A rate of
+// zero removes the limit.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) SetBroadcastRateLimit(rate float64, burst int) {
+	r.broadcastMu.Lock()
+	defer r.broadcastMu.Unlock()
+	if rate <= 0 {
+		r.broadcastLim = nil
+		return
+	}
+	r.broadcastLim = newTokenBucket(rate, burst, time.Now())
+}
+
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) allowBroadcast() error {
+	r.broadcastMu.Lock()
+	defer r.broadcastMu.Unlock()
+	if r.broadcastLim != nil && !r.broadcastLim.allow(time.Now()) {
+		return ErrBroadcastRateLimited
+	}
+	return nil
+}
+
+// broadcastLimited is allowBroadcast for the broadcast methods that have no
+// error to return: it reports whether the broadcast is over the rate limit,
+// recording it as the room's last error if so.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) broadcastLimited() bool {
+	if err := r.allowBroadcast(); err != nil {
+		r.recordError(ErrorSendFailure, err)
+		return true
+	}
+	return false
+}
+
+// BroadcastFromEvent sends data to every client except the one that caused
+// the event, so that a sender doesn't get its own message echoed back. If the
+// event has no client, data is sent to everyone.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) BroadcastFromEvent(event Event[ClientMetadata, DataType], data DataType) {
+	r.BroadcastExcept(event.Client, data)
+}
+
+// BroadcastChunked sends chunks, in order, to every client in the room, e.g.
+// a large document split into pieces, pacing delivery so that slow clients
+// aren't evicted for filling their buffer. Each client gets the chunks from
+// its own goroutine, waiting perClientDelay between chunks, and longer while
+// its buffer is close to full. It returns right away; delivery stops early
+// for clients that leave and when the room closes. Chunks are not recorded in
+// the room's history.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) BroadcastChunked(chunks []DataType, perClientDelay time.Duration) {
+	if r.broadcastLimited() {
+		return
+	}
+	// Back off with at least some delay, so that waiting on a full buffer
+	// doesn't turn into a busy loop.
+	backoff := max(perClientDelay, 10*time.Millisecond)
+	for _, client := range r.Clients() {
+		go func() {
+			for i, chunk := range chunks {
+				if i > 0 && !r.sleep(client, perClientDelay) {
+					return
+				}
+				for client.nearlyFull() {
+					if !r.sleep(client, backoff) {
+						return
+					}
+				}
+				if err := r.sendToClient(client, queuedItem[DataType]{data: chunk}); err != nil && !errors.Is(err, ErrDataDropped) {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// sleep waits for d and reports whether both the room and client are still
+// open afterwards.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) sleep(client *Client[ClientMetadata, DataType], d time.Duration) bool {
+	if d <= 0 {
+		return r.ctx.Err() == nil && client.ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-r.ctx.Done():
+		return false
+	case <-client.ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// BroadcastWithTTL sends data to all clients, but any client that hasn't
+// received it within ttl will skip it instead of receiving stale data.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) BroadcastWithTTL(data DataType, ttl time.Duration) {
+	if r.broadcastLimited() {
+		return
+	}
+	r.recordHistory(data)
+	r.broadcast(nil, queuedItem[DataType]{data: data, deadline: time.Now().Add(ttl)})
+}
+
+// BroadcastGen sends data to all clients, tagged with the room state
+// generation it was computed from. Clients that haven't received it by the
+// time the room's generation (see SetGeneration) has moved past gen will skip
+// it, so that slow clients only get data reflecting the latest state.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) BroadcastGen(gen uint64, data DataType) {
+	if r.broadcastLimited() {
+		return
+	}
+	r.recordHistory(data)
+	r.broadcast(nil, queuedItem[DataType]{data: data, gen: gen, roomGen: &r.generation})
+}
+
+// SetGeneration sets the room's current state generation, which invalidates
+// any queued data sent with BroadcastGen for an older generation.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) SetGeneration(gen uint64) {
+	r.generation.Store(gen)
+}
+
+// BroadcastJSON marshals v and broadcasts it framed with typeName, without
+// the type having to be registered first. The room must be configured with a
+// JSONCodec. Since clients reading through Receive decode the bytes, this is
+// mainly for server-originated messages to clients read with ReceiveBytes;
+// other clients will only get the message if typeName is registered.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) BroadcastJSON(typeName string, v any) error {
+	codec, ok := r.codec.(interface {
+		EncodeJSON(msgType string, v any) ([]byte, error)
+	})
+	if !ok {
+		return errors.New("cannot broadcast JSON: room has no JSON codec")
+	}
+	if err := r.allowBroadcast(); err != nil {
+		return err
+	}
+	raw, err := codec.EncodeJSON(typeName, v)
+	if err != nil {
+		return err
+	}
+	r.broadcast(nil, queuedItem[DataType]{raw: raw})
+	return nil
+}
+
+// BroadcastSync sends data to all clients and returns once every client has
+// either accepted it into its buffer or been handled as a failed send (which
+// removes it by default). This doesn't wait for delivery to the network. The
+// returned error joins the errors of all failed sends.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) BroadcastSync(data DataType) error {
+	if err := r.allowBroadcast(); err != nil {
+		return err
+	}
+	r.recordHistory(data)
+	_, err := r.broadcast(nil, queuedItem[DataType]{data: data})
+	return err
+}
+
+// BroadcastJoinedAfter sends data to the clients that joined after t.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) BroadcastJoinedAfter(t time.Time, data DataType) {
+	if r.broadcastLimited() {
+		return
+	}
+	r.broadcast(func(c *Client[ClientMetadata, DataType]) bool {
+		return c.ConnectedAt().After(t)
+	}, queuedItem[DataType]{data: data})
+}
+
+// BroadcastJoinedBefore sends data to the clients that joined before t.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) BroadcastJoinedBefore(t time.Time, data DataType) {
+	if r.broadcastLimited() {
+		return
+	}
+	r.broadcast(func(c *Client[ClientMetadata, DataType]) bool {
+		return c.ConnectedAt().Before(t)
+	}, queuedItem[DataType]{data: data})
+}
+
+// EnableHistory makes the room keep the last n messages broadcast in it,
+// overriding WithHistory. The most recent messages already recorded are kept,
+// as are the sequence numbers. If n is not positive, history is turned off and
+// discarded.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) EnableHistory(n int) {
+	if n <= 0 {
+		r.history.Store(nil)
+		return
+	}
+	// An existing history is resized in place rather than replaced, so that
+	// data being recorded concurrently isn't lost.
+	for {
+		if history := r.history.Load(); history != nil {
+			history.resize(n)
+			return
+		}
+		if r.history.CompareAndSwap(nil, newHistory[DataType](n)) {
+			return
+		}
+	}
+}
+
+// History returns the most recently broadcast data, oldest first. It's only
+// recorded if the hotel was created with WithHistory or EnableHistory was
+// called, which also bounds how much is kept. Only data broadcast to the
+// whole room (optionally except one client) is recorded, not data sent to
+// specific clients.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) History() []DataType {
+	data, _ := r.HistorySince(0)
+	return data
+}
+
+// HistorySince returns the recorded broadcast data after sequence number seq,
+// along with the sequence number of the most recent broadcast, which can be
+// passed to a later call to only get what was broadcast since then (e.g. for
+// a reconnecting client). Data that no longer fits in the history is omitted.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) HistorySince(seq uint64) ([]DataType, uint64) {
+	history := r.history.Load()
+	if history == nil {
+		return nil, 0
+	}
+	return history.since(seq)
+}
+
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) recordHistory(data DataType) {
+	if history := r.history.Load(); history != nil {
+		history.add(data)
+	}
+}
+
+// SendToMatching sends data to every client whose metadata matches the
+// predicate, e.g. all the tabs a user has open, and returns how many of them
+// accepted it. Clients that fail to receive the data are handled like in
+// Broadcast. The data is not recorded in the room's history.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) SendToMatching(predicate func(*ClientMetadata) bool, data DataType) int {
+	if r.broadcastLimited() {
+		return 0
+	}
+	sent, _ := r.broadcast(func(c *Client[ClientMetadata, DataType]) bool {
+		return predicate(c.Metadata())
+	}, queuedItem[DataType]{data: data})
+	return sent
+}
+
+// DeliveryResult is the outcome of sending data to a single client.
+type DeliveryResult[ClientMetadata, DataType any] struct {
+	Client *Client[ClientMetadata, DataType]
+	// Err is nil if the data was accepted into the client's buffer.
+	Err error
+}
+
+// BroadcastFuncResult sends data to every client whose metadata matches the
+// predicate and returns the outcome for each of them. Unlike other
+// broadcasts, clients that fail to receive the data are neither closed nor
+// removed from the room, even if their buffer stayed full for longer than
+// WithEvictAfter allows; a full client gets ErrDataDropped. If the broadcast
+// is over the room's rate limit, nothing is sent and the only result returned
+// has a nil Client and ErrBroadcastRateLimited.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) BroadcastFuncResult(predicate func(*ClientMetadata) bool, data DataType) []DeliveryResult[ClientMetadata, DataType] {
+	if err := r.allowBroadcast(); err != nil {
+		return []DeliveryResult[ClientMetadata, DataType]{{Err: err}}
+	}
 	r.mu.RLock()
 	clients := r.clients
 	r.mu.RUnlock()
+	var results []DeliveryResult[ClientMetadata, DataType]
 	for client := range clients {
-		if err := client.send(data); err != nil {
-			r.RemoveClient(client)
-			log.Printf("Failed to send data to client %p: %v", client, err)
+		if !predicate(client.Metadata()) {
+			continue
 		}
+		err := client.put(queuedItem[DataType]{data: data})
+		if errors.Is(err, ErrBufferFull) {
+			err = ErrDataDropped
+		}
+		results = append(results, DeliveryResult[ClientMetadata, DataType]{Client: client, Err: err})
 	}
+	return results
 }
 
-func (r *Room[RoomMetadata, ClientMetadata, DataType]) BroadcastExcept(except *Client[ClientMetadata, DataType], data DataType) {
+// BroadcastError is a client that a broadcast couldn't be sent to.
+type BroadcastError[ClientMetadata, DataType any] struct {
+	Client *Client[ClientMetadata, DataType]
+	Err    error
+}
+
+// BroadcastResult sends data to every client in the room like Broadcast, but
+// leaves the room's clients alone when sending fails and returns the failures
+// instead, so that the caller can decide what to do with each client. Like
+// with BroadcastFuncResult, that includes clients whose buffer stayed full for
+// longer than WithEvictAfter allows, which get ErrDataDropped. Drained clients
+// are skipped without an error. If the broadcast is
+// over the room's rate limit, nothing is sent and the only failure returned
+// has a nil Client and ErrBroadcastRateLimited.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) BroadcastResult(data DataType) []BroadcastError[ClientMetadata, DataType] {
+	if err := r.allowBroadcast(); err != nil {
+		return []BroadcastError[ClientMetadata, DataType]{{Err: err}}
+	}
+	r.recordHistory(data)
 	r.mu.RLock()
 	clients := r.clients
 	r.mu.RUnlock()
+	var failures []BroadcastError[ClientMetadata, DataType]
 	for client := range clients {
-		if client != except {
-			if err := client.send(data); err != nil {
-				r.RemoveClient(client)
-				log.Printf("Failed to send data to client %p: %v", client, err)
+		err := client.put(queuedItem[DataType]{data: data})
+		if errors.Is(err, ErrBufferFull) {
+			err = ErrDataDropped
+		}
+		if err != nil && !errors.Is(err, ErrClientDrained) {
+			failures = append(failures, BroadcastError[ClientMetadata, DataType]{Client: client, Err: err})
+		}
+	}
+	return failures
+}
+
+// broadcast sends the item to every client for which include returns true,
+// or to every client if include is nil, and returns how many clients accepted
+// it.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) broadcast(include func(*Client[ClientMetadata, DataType]) bool, item queuedItem[DataType]) (sent int, err error) {
+	var errs []error
+	r.mu.RLock()
+	clients := r.clients
+	onSendError := r.onSendError
+	r.mu.RUnlock()
+	for client := range clients {
+		if include != nil && !include(client) {
+			continue
+		}
+		// The client is only closed once it's decided that it should be
+		// removed, so that OnSendError can keep full clients.
+		if err := client.put(item); err != nil {
+			if errors.Is(err, ErrClientDrained) {
+				// Drained clients are intentionally not receiving data.
+				continue
+			}
+			errs = append(errs, fmt.Errorf("client %s: %w", client.ID(), err))
+			r.recordError(ErrorSendFailure, fmt.Errorf("client %s: %w", client.ID(), err))
+			if onSendError != nil {
+				if onSendError(client, err) {
+					r.removeFailedClient(client, err)
+				}
+				continue
+			}
+			if errors.Is(err, ErrDataDropped) {
+				// The client is still connected and may catch up.
+				continue
 			}
+			r.removeFailedClient(client, err)
+			r.log().Error("failed to send data to client", "client.id", client.ID(), "client.metadata", client.Metadata(), "error", err)
+			continue
 		}
+		sent++
+	}
+	return sent, errors.Join(errs...)
+}
+
+// removeFailedClient removes a client that data couldn't be sent to, and
+// tells the handler why with an EventClientRemoved. A client whose buffer
+// stayed full is closed, if it hasn't closed itself already.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) removeFailedClient(client *Client[ClientMetadata, DataType], err error) {
+	if errors.Is(err, ErrBufferFull) {
+		client.CloseWithReason(ReasonBufferFull)
 	}
+	r.RemoveClient(client)
+	r.Emit(Event[ClientMetadata, DataType]{
+		Type:   EventClientRemoved,
+		Client: client,
+		Err:    err,
+	})
+}
+
+// OnSendError sets a function that's called instead of logging when sending
+// a broadcast to a client fails. The client is removed from the room only if
+// it returns true. A client whose buffer stayed full (ErrBufferFull) is
+// still connected when fn is called, and is only closed if fn returns true.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) OnSendError(fn func(client *Client[ClientMetadata, DataType], err error) (remove bool)) {
+	r.mu.Lock()
+	r.onSendError = fn
+	r.mu.Unlock()
 }
 
+// Close closes the room and all of its clients. It's safe to call any number
+// of times from any goroutine; only the first call does anything.
 func (r *Room[RoomMetadata, ClientMetadata, DataType]) Close() {
+	r.close()
+}
+
+// close closes the room and reports whether this call was the one that did.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) close() bool {
+	// This is a flag rather than a sync.Once so that OnClose hooks (or code
+	// they trigger) can call Close again without deadlocking.
+	if !r.closed.CompareAndSwap(false, true) {
+		return false
+	}
+	r.closedAt.Store(time.Now().UnixNano())
 	r.cancelCloseTimer()
 	r.cancel()
 	r.mu.Lock()
-	for client := range r.clients {
-		client.Close()
-	}
+	clients := r.clients
 	r.clients = nil
+	r.clientList = nil
+	users := r.users
+	r.users = nil
+	hooks := r.onClose
+	r.onClose = nil
+	r.mu.Unlock()
+	// Clients leave in reverse join order, each with a leave event, so that
+	// presence tracking sees the same sequence every time. Clients that are
+	// still in other rooms stay open.
+	for _, client := range slices.SortedFunc(maps.Keys(clients), func(a, b *Client[ClientMetadata, DataType]) int {
+		return cmp.Compare(clients[b], clients[a])
+	}) {
+		if !client.removeRoom(r) {
+			client.CloseWithReason(ReasonRoomClosed)
+		}
+		reason := ReasonRoomClosed
+		if closeReason, closed := client.CloseReason(); closed {
+			reason = closeReason
+		}
+		r.Emit(Event[ClientMetadata, DataType]{
+			Type:   EventLeave,
+			Client: client,
+			Reason: reason,
+		})
+		if metrics := r.hotel.metrics; metrics != nil {
+			metrics.ClientLeft(r.ID(), reason)
+		}
+		if client.userID == "" {
+			continue
+		}
+		if users[client.userID]--; users[client.userID] == 0 {
+			r.Emit(Event[ClientMetadata, DataType]{
+				Type:   EventUserLeft,
+				Client: client,
+				Reason: reason,
+			})
+		}
+	}
+	for _, fn := range hooks {
+		fn(r)
+	}
+	r.subscribers.closeAll()
+	// Events emitted from now on are dropped, and the handler gets the ones
+	// already buffered before the channel reports that it's closed.
+	r.eventsMu.Lock()
+	r.eventsClosed = true
+	close(r.eventsCh)
+	r.eventsMu.Unlock()
+	return true
+}
+
+// closeIfIdle closes the room if it has had no clients for at least minIdle,
+// and reports whether it did.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) closeIfIdle(minIdle time.Duration) bool {
+	r.mu.Lock()
+	if len(r.clients) > 0 || r.emptySince.IsZero() || r.hotel.clock.Now().Sub(r.emptySince) < minIdle {
+		r.mu.Unlock()
+		return false
+	}
+	// Cancelling while holding the lock guarantees that no client can join
+	// between the check above and the room closing.
+	r.cancel()
 	r.mu.Unlock()
-	// TODO: Figure out if/when we should close the events channel. Close() is
-	// public and so are methods writing to the channel, so it's very difficult
-	// to prove that writes and close happen on the same goroutine.
-	// close(r.eventsCh)
+	return r.close()
+}
+
+// OnClose registers a function to run exactly once when the room closes. If
+// the room is already closed, fn is called immediately.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) OnClose(fn func(room *Room[RoomMetadata, ClientMetadata, DataType])) {
+	r.mu.Lock()
+	if !r.closed.Load() {
+		r.onClose = append(r.onClose, fn)
+		r.mu.Unlock()
+		return
+	}
+	r.mu.Unlock()
+	fn(r)
 }
 
 func (r *Room[RoomMetadata, ClientMetadata, DataType]) FindClient(predicate func(*ClientMetadata) bool) *Client[ClientMetadata, DataType] {
@@ -240,39 +1496,152 @@ func (r *Room[RoomMetadata, ClientMetadata, DataType]) FindClient(predicate func
 	return nil
 }
 
-func (r *Room[RoomMetadata, ClientMetadata, DataType]) Clients() []*Client[ClientMetadata, DataType] {
+// FindClients returns every client whose metadata matches the predicate, in
+// no particular order.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) FindClients(predicate func(*ClientMetadata) bool) []*Client[ClientMetadata, DataType] {
 	r.mu.RLock()
 	clients := r.clients
 	r.mu.RUnlock()
-	clientsSlice := make([]*Client[ClientMetadata, DataType], 0, len(r.clients))
+	var matches []*Client[ClientMetadata, DataType]
 	for client := range clients {
-		clientsSlice = append(clientsSlice, client)
+		if predicate(client.Metadata()) {
+			matches = append(matches, client)
+		}
 	}
-	return clientsSlice
+	return matches
+}
+
+// CountBy returns how many clients in the room there are for each key, e.g.
+// the number of players per team.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) CountBy(key func(metadata *ClientMetadata) string) map[string]int {
+	r.mu.RLock()
+	clients := r.clients
+	r.mu.RUnlock()
+	counts := make(map[string]int)
+	for client := range clients {
+		counts[key(client.Metadata())]++
+	}
+	return counts
+}
+
+// ClientCount returns the number of clients in the room.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) ClientCount() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.clients)
+}
+
+// Clients returns the clients in the room. The slice is shared between calls
+// until a client joins or leaves, so it must not be modified.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) Clients() []*Client[ClientMetadata, DataType] {
+	r.mu.RLock()
+	clientList := r.clientList
+	r.mu.RUnlock()
+	if clientList != nil {
+		return clientList
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.clientList == nil {
+		r.clientList = make([]*Client[ClientMetadata, DataType], 0, len(r.clients))
+		for client := range r.clients {
+			r.clientList = append(r.clientList, client)
+		}
+	}
+	return r.clientList
 }
 
 func (r *Room[RoomMetadata, ClientMetadata, DataType]) scheduleClose() {
 	r.closeTimerMu.Lock()
 	defer r.closeTimerMu.Unlock()
+	r.closeScheduledAt = r.hotel.clock.Now()
+	r.startCloseTimer(r.closeDelay)
+}
 
+// startCloseTimer replaces the close timer with one that fires after delay,
+// or with none if delay is negative. r.closeTimerMu must be held.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) startCloseTimer(delay time.Duration) {
 	if r.closeTimer != nil {
 		r.closeTimer.Stop()
+		r.closeTimer = nil
+	}
+	if delay < 0 {
+		return
 	}
-	r.closeTimer = time.AfterFunc(DefaultAutoCloseDelay, func() {
+	r.closeTimer = r.hotel.clock.AfterFunc(delay, func() {
 		r.mu.RLock()
 		isEmpty := len(r.clients) == 0
 		r.mu.RUnlock()
 
-		if isEmpty {
-			r.Close()
+		if !isEmpty {
+			return
 		}
+
+		r.closeTimerMu.Lock()
+		canAutoClose := r.canAutoClose
+		r.closeTimerMu.Unlock()
+		if canAutoClose != nil && !canAutoClose(r) {
+			r.scheduleClose()
+			return
+		}
+		r.Close()
 	})
 }
 
+// SetAutoCloseDelay sets how long the room stays open after its last client
+// leaves, overriding the hotel's WithAutoCloseDelay. Zero closes the room as
+// soon as it's empty, and a negative delay means it never closes by itself.
+// If the room is already waiting to close, it's rescheduled to close once the
+// new delay has passed since it started waiting.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) SetAutoCloseDelay(d time.Duration) {
+	r.closeTimerMu.Lock()
+	defer r.closeTimerMu.Unlock()
+	r.closeDelay = d
+	if r.closeScheduledAt.IsZero() {
+		return
+	}
+	if d >= 0 {
+		d = max(0, d-r.hotel.clock.Now().Sub(r.closeScheduledAt))
+	}
+	r.startCloseTimer(d)
+}
+
+// SetAdmission sets a function that's consulted before each client joins the
+// room, including through AddClient. Joins are serialized while it runs, so it
+// can safely base its decision on the room's current state, such as its
+// clients, but it must not add clients to the room itself. Pass nil to admit
+// everyone again.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) SetAdmission(fn AdmissionFunc[RoomMetadata, ClientMetadata, DataType]) {
+	r.admitMu.Lock()
+	r.admit = fn
+	r.admitMu.Unlock()
+}
+
+// SetMaxClients limits how many clients the room can hold, overriding
+// WithMaxClients. Once the room is full, adding a client fails with
+// ErrRoomFull. Clients already in the room are not affected if the limit is
+// lowered. Zero removes the limit.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) SetMaxClients(n int) {
+	r.mu.Lock()
+	r.maxClients = n
+	r.mu.Unlock()
+}
+
+// SetCanAutoClose registers a function that is consulted before an empty room
+// is automatically closed. If it returns false, the close is deferred for
+// another auto-close delay. It's called on the timer's goroutine, so it must
+// be quick and safe to call concurrently with the room handler.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) SetCanAutoClose(fn func(room *Room[RoomMetadata, ClientMetadata, DataType]) bool) {
+	r.closeTimerMu.Lock()
+	r.canAutoClose = fn
+	r.closeTimerMu.Unlock()
+}
+
 func (r *Room[RoomMetadata, ClientMetadata, DataType]) cancelCloseTimer() {
 	r.closeTimerMu.Lock()
 	defer r.closeTimerMu.Unlock()
 
+	r.closeScheduledAt = time.Time{}
 	if r.closeTimer != nil {
 		r.closeTimer.Stop()
 		r.closeTimer = nil
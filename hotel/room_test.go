@@ -0,0 +1,2020 @@
+package hotel
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testRoomMetadata struct{}
+
+type testClientMetadata struct {
+	Name string
+}
+
+func testInit(ctx context.Context, id string) (*testRoomMetadata, error) {
+	return &testRoomMetadata{}, nil
+}
+
+type testRoom = Room[testRoomMetadata, testClientMetadata, string]
+
+// newTestRoom creates a hotel with a handler that drains events and returns
+// its "test" room, which is closed when the test ends.
+func newTestRoom(t *testing.T, opts ...Option) *testRoom {
+	t.Helper()
+	return newTestRoomWithHandler(t, func(ctx context.Context, room *testRoom) {
+		for range room.Events() {
+		}
+	}, opts...)
+}
+
+// newTestRoomWithHandler is like newTestRoom but runs the given handler.
+func newTestRoomWithHandler(t *testing.T, handler func(context.Context, *testRoom), opts ...Option) *testRoom {
+	t.Helper()
+	h := New(testInit, handler, opts...)
+	room, err := h.GetOrCreateRoom("test")
+	if err != nil {
+		t.Fatalf("GetOrCreateRoom failed: %v", err)
+	}
+	t.Cleanup(room.Close)
+	return room
+}
+
+type testMessageRoom = Room[testRoomMetadata, testClientMetadata, Message]
+
+// newTestMessageRoom is like newTestRoomWithHandler, but for a room of
+// Messages with a JSONCodec that knows testChatMessage.
+func newTestMessageRoom(t *testing.T, handler func(context.Context, *testMessageRoom), opts ...Option) *testMessageRoom {
+	t.Helper()
+	registry := MessageRegistry[Message]{}
+	registry.Register(&testChatMessage{})
+	h := New(testInit, handler, append([]Option{WithCodec(NewJSONCodec(registry))}, opts...)...)
+	room, err := h.GetOrCreateRoom("test")
+	if err != nil {
+		t.Fatalf("GetOrCreateRoom failed: %v", err)
+	}
+	t.Cleanup(room.Close)
+	return room
+}
+
+// newTestClient adds a client with the given name to the room.
+func newTestClient(t *testing.T, room *testRoom, name string) *Client[testClientMetadata, string] {
+	t.Helper()
+	client, err := room.NewClient(&testClientMetadata{Name: name})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	return client
+}
+
+// receiveNext returns the next data from a client's receive channel, failing
+// the test if none arrives in time.
+func receiveNext[DataType any](t *testing.T, received <-chan DataType) DataType {
+	t.Helper()
+	select {
+	case data := <-received:
+		return data
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for data")
+		var zero DataType
+		return zero
+	}
+}
+
+func TestHandlerRestart(t *testing.T) {
+	restarted := make(chan struct{})
+	var calls int
+	room := newTestRoomWithHandler(t, func(ctx context.Context, room *testRoom) {
+		calls++
+		if calls == 1 {
+			panic("boom")
+		}
+		for {
+			select {
+			case event := <-room.Events():
+				if event.Type == EventHandlerRestarted {
+					close(restarted)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}, WithHandlerRestart(1, nil))
+
+	select {
+	case <-restarted:
+	case <-time.After(time.Second):
+		t.Fatal("handler was not restarted")
+	}
+	if err := room.ctx.Err(); err != nil {
+		t.Fatalf("room should still be open after restart: %v", err)
+	}
+	if lastErr, ok := room.LastError(); !ok || lastErr.Category != ErrorPanic {
+		t.Errorf("expected last error to be a panic, got %v", lastErr)
+	}
+}
+
+func TestHandlerRestartedEventOrder(t *testing.T) {
+	gate := make(chan struct{})
+	got := make(chan []EventType, 1)
+	var calls int
+	room := newTestRoomWithHandler(t, func(ctx context.Context, room *testRoom) {
+		calls++
+		if calls == 1 {
+			<-gate
+			panic("boom")
+		}
+		var types []EventType
+		for event := range room.Events() {
+			types = append(types, event.Type)
+			if event.Type == EventHandlerRestarted {
+				got <- types
+				break
+			}
+		}
+		<-ctx.Done()
+	}, WithHandlerRestart(1, nil))
+
+	room.Emit(Event[testClientMetadata, string]{Type: EventCustom})
+	close(gate)
+	select {
+	case types := <-got:
+		if !slices.Equal(types, []EventType{EventCustom, EventHandlerRestarted}) {
+			t.Errorf("expected queued events before EventHandlerRestarted, got %v", types)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("handler was not restarted")
+	}
+}
+
+func TestHandlerStartupBuffer(t *testing.T) {
+	gate := make(chan struct{})
+	got := make(chan int, 1)
+	room := newTestRoomWithHandler(t, func(ctx context.Context, room *testRoom) {
+		// Slow setup before reading any events.
+		<-gate
+		n := 0
+		for event := range room.Events() {
+			if event.Type == EventCustom {
+				n++
+			}
+			if n == 1000 {
+				got <- n
+			}
+		}
+	})
+
+	for range 1000 {
+		room.Emit(Event[testClientMetadata, string]{Type: EventCustom})
+	}
+	if room.closed.Load() {
+		t.Fatal("expected events during the handler's startup to be buffered")
+	}
+	close(gate)
+	select {
+	case <-got:
+	case <-time.After(time.Second):
+		t.Fatal("expected the handler to get every buffered event")
+	}
+}
+
+func TestCloseConcurrent(t *testing.T) {
+	room := newTestRoom(t)
+	var hookCalls atomic.Int32
+	room.OnClose(func(*testRoom) {
+		hookCalls.Add(1)
+	})
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			room.Close()
+		}()
+	}
+	wg.Wait()
+
+	if n := hookCalls.Load(); n != 1 {
+		t.Errorf("expected OnClose hook to run once, ran %d times", n)
+	}
+}
+
+func TestHandleClientDataAfterClose(t *testing.T) {
+	room := newTestRoom(t)
+	client := newTestClient(t, room, "alice")
+	room.Close()
+
+	if err := room.HandleClientData(client, "hello"); !errors.Is(err, ErrRoomClosed) {
+		t.Errorf("expected ErrRoomClosed, got %v", err)
+	}
+}
+
+func TestClientCloseRemovesFromRoom(t *testing.T) {
+	room := newTestRoom(t)
+	client := newTestClient(t, room, "alice")
+
+	client.Close()
+
+	if clients := room.Clients(); len(clients) != 0 {
+		t.Errorf("expected closed client to be removed from room, got %d clients", len(clients))
+	}
+	if RoomOf[testRoomMetadata](client) != nil {
+		t.Error("expected closed client to no longer have a room")
+	}
+}
+
+func TestSubscribeDoesNotBlockRoom(t *testing.T) {
+	room := newTestRoomWithHandler(t, func(ctx context.Context, room *testRoom) {
+		for {
+			select {
+			case <-room.Events():
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+	events, unsubscribe := room.Subscribe(1, DropOldest)
+	client := newTestClient(t, room, "alice")
+
+	for _, data := range []string{"a", "b", "c"} {
+		if err := room.HandleClientData(client, data); err != nil {
+			t.Fatalf("HandleClientData failed: %v", err)
+		}
+	}
+	if room.ctx.Err() != nil {
+		t.Fatal("slow subscriber should not close the room")
+	}
+	if event := <-events; event.Data != "c" {
+		t.Errorf("expected only the newest event to be kept, got %q", event.Data)
+	}
+
+	unsubscribe()
+	if _, ok := <-events; ok {
+		t.Error("expected channel to be closed after unsubscribe")
+	}
+}
+
+func TestDependencies(t *testing.T) {
+	type deps struct{ Name string }
+	var got string
+	h := New(func(ctx context.Context, id string) (*testRoomMetadata, error) {
+		d, ok := Dependencies[*deps](ctx)
+		if !ok {
+			return nil, errors.New("missing dependencies")
+		}
+		got = d.Name
+		return &testRoomMetadata{}, nil
+	}, func(ctx context.Context, room *testRoom) {
+		<-ctx.Done()
+	}, WithDependencies(&deps{Name: "db"}))
+
+	room, err := h.GetOrCreateRoom("test")
+	if err != nil {
+		t.Fatalf("GetOrCreateRoom failed: %v", err)
+	}
+	defer room.Close()
+	if got != "db" {
+		t.Errorf("expected init to see dependencies, got %q", got)
+	}
+}
+
+func TestClientContextCause(t *testing.T) {
+	room := newTestRoom(t)
+	client := newTestClient(t, room, "alice")
+
+	client.CloseWithReason(ReasonKicked)
+	room.RemoveClient(client)
+
+	if cause := context.Cause(client.Context()); !errors.Is(cause, ReasonKicked) {
+		t.Errorf("expected cause %v, got %v", ReasonKicked, cause)
+	}
+}
+
+func TestDirectDelivery(t *testing.T) {
+	room := newTestRoom(t, WithDirectDelivery(), WithEvictAfter(math.MaxInt32))
+	client := newTestClient(t, room, "alice")
+
+	// Nobody is receiving yet, so the data is dropped.
+	if err := room.SendToClient(client, "dropped"); !errors.Is(err, ErrDataDropped) {
+		t.Fatalf("expected ErrDataDropped, got %v", err)
+	}
+
+	received := client.Receive()
+	done := make(chan error)
+	go func() {
+		// Retry until the receiver below is waiting.
+		for {
+			err := room.SendToClient(client, "hello")
+			if !errors.Is(err, ErrDataDropped) {
+				done <- err
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	if data := <-received; data != "hello" {
+		t.Errorf("expected hello, got %q", data)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("SendToClient failed: %v", err)
+	}
+}
+
+func TestTransferAll(t *testing.T) {
+	h := New(testInit, func(ctx context.Context, room *testRoom) {
+		<-ctx.Done()
+	})
+	src, err := h.GetOrCreateRoom("src")
+	if err != nil {
+		t.Fatalf("GetOrCreateRoom failed: %v", err)
+	}
+	defer src.Close()
+	dest, err := h.GetOrCreateRoom("dest")
+	if err != nil {
+		t.Fatalf("GetOrCreateRoom failed: %v", err)
+	}
+	defer dest.Close()
+	for _, name := range []string{"alice", "bob"} {
+		if _, err := src.NewClient(&testClientMetadata{Name: name}); err != nil {
+			t.Fatalf("NewClient failed: %v", err)
+		}
+	}
+	client := src.Clients()[0]
+	if err := src.SendToClient(client, "buffered"); err != nil {
+		t.Fatalf("SendToClient failed: %v", err)
+	}
+
+	moved, err := src.TransferAll(dest)
+	if err != nil {
+		t.Fatalf("TransferAll failed: %v", err)
+	}
+	if moved != 2 || len(src.Clients()) != 0 || len(dest.Clients()) != 2 {
+		t.Fatalf("expected 2 clients moved to dest, moved %d (src has %d, dest has %d)", moved, len(src.Clients()), len(dest.Clients()))
+	}
+	if RoomOf[testRoomMetadata](client) != dest {
+		t.Error("expected client's room to be dest")
+	}
+	if client.Context().Err() != nil {
+		t.Fatal("expected transferred client to stay open")
+	}
+	if data := <-client.Receive(); data != "buffered" {
+		t.Errorf("expected buffered data to survive transfer, got %q", data)
+	}
+}
+
+func TestPurgeQueued(t *testing.T) {
+	room := newTestRoom(t)
+	client := newTestClient(t, room, "alice")
+	for _, data := range []string{"a", "secret", "b"} {
+		if err := room.SendToClient(client, data); err != nil {
+			t.Fatalf("SendToClient failed: %v", err)
+		}
+	}
+
+	if n := client.PurgeQueued(func(data string) bool { return data == "secret" }); n != 1 {
+		t.Fatalf("expected 1 item purged, got %d", n)
+	}
+	received := client.Receive()
+	for _, want := range []string{"a", "b"} {
+		if data := <-received; data != want {
+			t.Errorf("expected %q, got %q", want, data)
+		}
+	}
+}
+
+func TestDrain(t *testing.T) {
+	room := newTestRoom(t)
+	client := newTestClient(t, room, "alice")
+	// Nothing leaves the buffer until the consumer starts receiving.
+	room.Broadcast("a")
+	room.Broadcast("b")
+
+	if got := fmt.Sprint(client.Drain()); got != "[a b]" {
+		t.Errorf("expected the buffered data [a b], got %s", got)
+	}
+	if err := client.Send("c"); !errors.Is(err, ErrClientDrained) {
+		t.Errorf("expected ErrClientDrained, got %v", err)
+	}
+	if err := room.SendToClient(client, "d"); !errors.Is(err, ErrClientDrained) {
+		t.Errorf("expected ErrClientDrained from the room, got %v", err)
+	}
+	room.Broadcast("e")
+	if room.ClientCount() != 1 || client.Context().Err() != nil {
+		t.Error("expected the drained client to stay open in its room")
+	}
+}
+
+func TestRemoveClientGraceful(t *testing.T) {
+	room := newTestRoom(t)
+	client := newTestClient(t, room, "alice")
+	if err := room.SendToClient(client, "last"); err != nil {
+		t.Fatalf("SendToClient failed: %v", err)
+	}
+
+	received := make(chan string, 1)
+	go func() {
+		for data := range client.Receive() {
+			received <- data
+		}
+		close(received)
+	}()
+	if err := room.RemoveClientGraceful(client, time.Second); err != nil {
+		t.Fatalf("RemoveClientGraceful failed: %v", err)
+	}
+	if data := <-received; data != "last" {
+		t.Errorf("expected data sent before removal to be delivered, got %q", data)
+	}
+	if reason, _ := client.CloseReason(); reason != ReasonRemoved {
+		t.Errorf("expected client to be closed with %v, got %v", ReasonRemoved, reason)
+	}
+}
+
+func TestCloseEmitsLeavesInReverseJoinOrder(t *testing.T) {
+	room := newTestRoom(t)
+	names := []string{"alice", "bob", "carol"}
+	for _, name := range names {
+		if _, err := room.NewClient(&testClientMetadata{Name: name}); err != nil {
+			t.Fatalf("NewClient failed: %v", err)
+		}
+	}
+	events, _ := room.Subscribe(len(names), DropNewest)
+
+	room.Close()
+
+	for i := len(names) - 1; i >= 0; i-- {
+		event := <-events
+		if event.Type != EventLeave || event.Reason != ReasonRoomClosed {
+			t.Fatalf("expected leave with %v, got %v with %v", ReasonRoomClosed, event.Type, event.Reason)
+		}
+		if name := event.Client.Metadata().Name; name != names[i] {
+			t.Errorf("expected %s to leave, got %s", names[i], name)
+		}
+	}
+}
+
+func TestHandleClientDataFromMultipleTransports(t *testing.T) {
+	room := newTestRoom(t)
+	client := newTestClient(t, room, "alice")
+	const perTransport = 100
+	events, _ := room.Subscribe(2*perTransport, DropNewest)
+
+	var wg sync.WaitGroup
+	for _, transport := range []string{"ws", "http"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range perTransport {
+				if err := room.HandleClientData(client, fmt.Sprintf("%s %d", transport, i)); err != nil {
+					t.Errorf("HandleClientData failed: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	next := map[string]int{}
+	for range 2 * perTransport {
+		event := <-events
+		var transport string
+		var i int
+		fmt.Sscanf(event.Data, "%s %d", &transport, &i)
+		if i != next[transport] {
+			t.Fatalf("expected %s %d, got %q", transport, next[transport], event.Data)
+		}
+		next[transport]++
+	}
+}
+
+func TestBroadcastChunkedPacesSlowClients(t *testing.T) {
+	room := newTestRoom(t)
+	client := newTestClient(t, room, "alice")
+
+	// More chunks than fit in the client's buffer.
+	chunks := make([]string, 1000)
+	for i := range chunks {
+		chunks[i] = fmt.Sprint(i)
+	}
+	room.BroadcastChunked(chunks, 0)
+	received := client.Receive()
+	for i := range chunks {
+		select {
+		case data := <-received:
+			if data != chunks[i] {
+				t.Fatalf("expected chunk %s, got %s", chunks[i], data)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for chunk %d", i)
+		}
+	}
+	if client.DropCount() != 0 {
+		t.Errorf("expected no drops, got %d", client.DropCount())
+	}
+}
+
+func TestBroadcastWithTTL(t *testing.T) {
+	room := newTestRoom(t)
+	client := newTestClient(t, room, "alice")
+	received := client.Receive()
+
+	// The client doesn't read while the short TTL runs out.
+	room.Broadcast("first")
+	room.BroadcastWithTTL("stale", time.Millisecond)
+	room.BroadcastWithTTL("fresh", time.Hour)
+	time.Sleep(20 * time.Millisecond)
+	for _, want := range []string{"first", "fresh"} {
+		if got := receiveNext(t, received); got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	}
+}
+
+func TestBroadcastGen(t *testing.T) {
+	room := newTestRoom(t)
+	client := newTestClient(t, room, "alice")
+	received := client.Receive()
+
+	room.Broadcast("first")
+	room.BroadcastGen(1, "old state")
+	room.SetGeneration(2)
+	room.BroadcastGen(2, "new state")
+	for _, want := range []string{"first", "new state"} {
+		if got := receiveNext(t, received); got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	}
+}
+
+func TestBroadcastExceptSet(t *testing.T) {
+	room := newTestRoom(t)
+	except := NewClientSet[testClientMetadata, string]()
+	var included *Client[testClientMetadata, string]
+	for _, name := range []string{"alice", "bob", "carol"} {
+		client, err := room.NewClient(&testClientMetadata{Name: name})
+		if err != nil {
+			t.Fatalf("NewClient failed: %v", err)
+		}
+		if name == "bob" {
+			included = client
+		} else {
+			except.Add(client)
+		}
+	}
+
+	room.BroadcastExceptSet(except, "hi")
+
+	if n := included.pending.Load(); n != 1 {
+		t.Errorf("expected included client to get 1 item, got %d", n)
+	}
+	for client := range except.All() {
+		if n := client.pending.Load(); n != 0 {
+			t.Errorf("expected excluded client to get nothing, got %d items", n)
+		}
+	}
+}
+
+func TestBroadcastJoinedAfterBefore(t *testing.T) {
+	clock := &testClock{now: time.Unix(0, 0)}
+	room := newTestRoom(t, WithClock(clock))
+	alice := newTestClient(t, room, "alice")
+	clock.Advance(time.Minute)
+	cutoff := clock.Now()
+	clock.Advance(time.Minute)
+	bob := newTestClient(t, room, "bob")
+
+	room.BroadcastJoinedAfter(cutoff, "welcome")
+	if a, b := alice.pending.Load(), bob.pending.Load(); a != 0 || b != 1 {
+		t.Errorf("expected only bob to get data, got alice %d and bob %d items", a, b)
+	}
+	room.BroadcastJoinedBefore(cutoff, "veterans")
+	if a, b := alice.pending.Load(), bob.pending.Load(); a != 1 || b != 1 {
+		t.Errorf("expected only alice to get data, got alice %d and bob %d items", a, b)
+	}
+}
+
+func TestClientsCached(t *testing.T) {
+	room := newTestRoom(t)
+	alice := newTestClient(t, room, "alice")
+	first := room.Clients()
+	if again := room.Clients(); len(again) != 1 || &again[0] != &first[0] {
+		t.Error("expected the client list to be reused until the clients change")
+	}
+
+	bob := newTestClient(t, room, "bob")
+	if clients := room.Clients(); len(clients) != 2 || !slices.Contains(clients, bob) {
+		t.Errorf("expected the list to include bob after joining, got %v", clients)
+	}
+	if len(first) != 1 || first[0] != alice {
+		t.Error("expected the earlier list to be left alone")
+	}
+	room.RemoveClient(alice)
+	if clients := room.Clients(); len(clients) != 1 || clients[0] != bob {
+		t.Errorf("expected only bob after alice left, got %v", clients)
+	}
+}
+
+func TestCountBy(t *testing.T) {
+	room := newTestRoom(t)
+	initial := func(metadata *testClientMetadata) string { return metadata.Name[:1] }
+	if counts := room.CountBy(initial); len(counts) != 0 {
+		t.Errorf("expected no counts for an empty room, got %v", counts)
+	}
+	for _, name := range []string{"alice", "bob", "anna"} {
+		newTestClient(t, room, name)
+	}
+	if got := fmt.Sprint(room.CountBy(initial)); got != "map[a:2 b:1]" {
+		t.Errorf("expected map[a:2 b:1], got %s", got)
+	}
+	carol := newTestClient(t, room, "carol")
+	carol.SwapMetadata(&testClientMetadata{Name: "alex"})
+	if got := fmt.Sprint(room.CountBy(initial)); got != "map[a:3 b:1]" {
+		t.Errorf("expected counts to use current metadata, got %s", got)
+	}
+}
+
+func TestClientInMultipleRooms(t *testing.T) {
+	h := New(testInit, func(ctx context.Context, room *testRoom) {
+		<-ctx.Done()
+	})
+	first, err := h.GetOrCreateRoom("first")
+	if err != nil {
+		t.Fatalf("GetOrCreateRoom failed: %v", err)
+	}
+	defer first.Close()
+	second, err := h.GetOrCreateRoom("second")
+	if err != nil {
+		t.Fatalf("GetOrCreateRoom failed: %v", err)
+	}
+	defer second.Close()
+	client, err := first.NewClient(&testClientMetadata{Name: "alice"})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	if err := second.AddClient(client); err != nil {
+		t.Fatalf("AddClient failed: %v", err)
+	}
+
+	// Leaving one room keeps the client open for the other.
+	first.RemoveClient(client)
+	if client.Context().Err() != nil {
+		t.Fatal("expected client to stay open while in another room")
+	}
+	if RoomOf[testRoomMetadata](client) != second {
+		t.Fatal("expected client to still be in the second room")
+	}
+
+	if err := first.AddClient(client); err != nil {
+		t.Fatalf("AddClient failed: %v", err)
+	}
+	client.Close()
+	if len(first.Clients()) != 0 || len(second.Clients()) != 0 {
+		t.Error("expected closed client to be removed from every room")
+	}
+	if rooms := RoomsOf[testRoomMetadata](client); len(rooms) != 0 {
+		t.Errorf("expected closed client to have no rooms, got %d", len(rooms))
+	}
+}
+
+func TestReinitialize(t *testing.T) {
+	var version atomic.Int32
+	h := New(func(ctx context.Context, id string) (*testClientMetadata, error) {
+		if version.Add(1) == 3 {
+			return nil, errors.New("init failed")
+		}
+		return &testClientMetadata{Name: fmt.Sprint(version.Load())}, nil
+	}, func(ctx context.Context, room *Room[testClientMetadata, testClientMetadata, string]) {
+		<-ctx.Done()
+	})
+	room, err := h.GetOrCreateRoom("test")
+	if err != nil {
+		t.Fatalf("GetOrCreateRoom failed: %v", err)
+	}
+	defer room.Close()
+	client, err := room.NewClient(&testClientMetadata{Name: "alice"})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	if err := room.Reinitialize(context.Background()); err != nil {
+		t.Fatalf("Reinitialize failed: %v", err)
+	}
+	if name := room.Metadata().Name; name != "2" {
+		t.Errorf("expected fresh metadata, got %q", name)
+	}
+	if err := room.Reinitialize(context.Background()); err == nil {
+		t.Fatal("expected Reinitialize to fail")
+	}
+	if name := room.Metadata().Name; name != "2" {
+		t.Errorf("expected old metadata to be kept after failure, got %q", name)
+	}
+	if client.Context().Err() != nil || len(room.Clients()) != 1 {
+		t.Error("expected client to stay in the room")
+	}
+}
+
+func TestBroadcastRateLimit(t *testing.T) {
+	room := newTestRoom(t)
+	room.SetBroadcastRateLimit(1, 2)
+
+	for i := range 2 {
+		if err := room.BroadcastSync("hi"); err != nil {
+			t.Fatalf("broadcast %d failed: %v", i, err)
+		}
+	}
+	if err := room.BroadcastSync("hi"); !errors.Is(err, ErrBroadcastRateLimited) {
+		t.Errorf("expected ErrBroadcastRateLimited, got %v", err)
+	}
+	room.Broadcast("hi")
+	if lastErr, ok := room.LastError(); !ok || !errors.Is(lastErr, ErrBroadcastRateLimited) {
+		t.Errorf("expected dropped broadcast to be recorded, got %v", lastErr)
+	}
+	if failures := room.BroadcastResult("hi"); len(failures) != 1 || failures[0].Client != nil || !errors.Is(failures[0].Err, ErrBroadcastRateLimited) {
+		t.Errorf("expected a single rate limit failure, got %v", failures)
+	}
+	everyone := func(*testClientMetadata) bool { return true }
+	if results := room.BroadcastFuncResult(everyone, "hi"); len(results) != 1 || results[0].Client != nil || !errors.Is(results[0].Err, ErrBroadcastRateLimited) {
+		t.Errorf("expected a single rate limit result, got %v", results)
+	}
+	client := newTestClient(t, room, "alice")
+	if n := room.SendToMatching(everyone, "hi"); n != 0 {
+		t.Errorf("expected no clients to get data over the limit, got %d", n)
+	}
+	room.BroadcastChunked([]string{"a", "b"}, 0)
+	time.Sleep(10 * time.Millisecond)
+	if n := client.pending.Load(); n != 0 {
+		t.Errorf("expected no chunks to be sent over the limit, got %d", n)
+	}
+
+	room.SetBroadcastRateLimit(0, 0)
+	if err := room.BroadcastSync("hi"); err != nil {
+		t.Errorf("expected removed limit to allow broadcast, got %v", err)
+	}
+}
+
+func TestUpdateAndBroadcastRateLimit(t *testing.T) {
+	room := newTestRoom(t)
+	room.SetBroadcastRateLimit(1, 1)
+	client := newTestClient(t, room, "alice")
+
+	var updates int
+	update := func(*testRoomMetadata) (string, bool) {
+		updates++
+		return "updated", true
+	}
+	if err := room.UpdateAndBroadcast(update); err != nil {
+		t.Fatalf("UpdateAndBroadcast failed: %v", err)
+	}
+	if err := room.UpdateAndBroadcast(update); !errors.Is(err, ErrBroadcastRateLimited) {
+		t.Errorf("expected ErrBroadcastRateLimited, got %v", err)
+	}
+	if updates != 1 {
+		t.Errorf("expected a rate limited call not to update, got %d updates", updates)
+	}
+	if data := <-client.Receive(); data != "updated" {
+		t.Errorf("expected %q, got %q", "updated", data)
+	}
+}
+
+func TestClientDebug(t *testing.T) {
+	room := newTestRoom(t)
+	client := newTestClient(t, room, "alice")
+	if err := room.HandleClientData(client, "hello"); err != nil {
+		t.Fatalf("HandleClientData failed: %v", err)
+	}
+	if err := room.SendToClient(client, "hi"); err != nil {
+		t.Fatalf("SendToClient failed: %v", err)
+	}
+
+	info := client.Debug()
+	if info.Metadata.Name != "alice" || len(info.Rooms) != 1 || info.Rooms[0] != "test" {
+		t.Errorf("unexpected client info %+v", info)
+	}
+	if info.Received != 1 || info.Queued != 1 || info.Closed {
+		t.Errorf("expected 1 received and 1 queued, got %+v", info)
+	}
+	<-client.Receive()
+	// The count is updated right after the consumer received the data.
+	deadline := time.Now().Add(time.Second)
+	for client.Debug().Sent != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected 1 sent")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	client.CloseWithReason(ReasonKicked)
+	if info := client.Debug(); !info.Closed || info.CloseReason != ReasonKicked {
+		t.Errorf("expected client to be kicked, got %+v", info)
+	}
+}
+
+func TestAdmission(t *testing.T) {
+	room := newTestRoom(t)
+	room.SetAdmission(func(room *testRoom, metadata *testClientMetadata) (bool, error) {
+		switch room.ClientCount() {
+		case 0:
+			return true, nil
+		case 1:
+			metadata.Name = "spectator"
+			return true, nil
+		}
+		return false, nil
+	})
+
+	newTestClient(t, room, "alice")
+	bob := newTestClient(t, room, "bob")
+	if name := bob.Metadata().Name; name != "spectator" {
+		t.Errorf("expected late joiner to be tagged, got %q", name)
+	}
+	if _, err := room.NewClient(&testClientMetadata{Name: "carol"}); !errors.Is(err, ErrAdmissionDenied) {
+		t.Errorf("expected ErrAdmissionDenied, got %v", err)
+	}
+	if n := room.ClientCount(); n != 2 {
+		t.Errorf("expected 2 clients, got %d", n)
+	}
+}
+
+func TestAdmissionSharedClient(t *testing.T) {
+	lobby := newTestRoom(t)
+	alice := newTestClient(t, lobby, "alice")
+	original := alice.Metadata()
+	game := newTestRoom(t)
+	game.SetAdmission(func(room *testRoom, metadata *testClientMetadata) (bool, error) {
+		metadata.Name = "spectator"
+		return true, nil
+	})
+	if err := game.AddClient(alice); err != nil {
+		t.Fatalf("AddClient failed: %v", err)
+	}
+	if original.Name != "alice" {
+		t.Errorf("expected the original metadata to be left alone, got %q", original.Name)
+	}
+	if name := alice.Metadata().Name; name != "spectator" {
+		t.Errorf("expected the admitted copy to be published, got %q", name)
+	}
+}
+
+func TestAdmissionRejectReason(t *testing.T) {
+	room := newTestRoom(t)
+	room.SetAdmission(func(room *testRoom, metadata *testClientMetadata) (bool, error) {
+		return false, nil
+	})
+	client := newClient[testClientMetadata, string](&testClientMetadata{Name: "alice"}, room.clientOpts)
+	if err := room.addNewClient(client); !errors.Is(err, ErrAdmissionDenied) {
+		t.Fatalf("expected ErrAdmissionDenied, got %v", err)
+	}
+	if cause := context.Cause(client.Context()); cause != ReasonRejected {
+		t.Errorf("expected ReasonRejected, got %v", cause)
+	}
+	if n := room.ClientCount(); n != 0 {
+		t.Errorf("expected no clients, got %d", n)
+	}
+}
+
+func TestStartTicker(t *testing.T) {
+	clock := &testClock{now: time.Unix(0, 0)}
+	ticks := make(chan string, 100)
+	room := newTestRoomWithHandler(t, func(ctx context.Context, room *testRoom) {
+		for event := range room.Events() {
+			if event.Type == EventTick {
+				ticks <- event.Ticker
+			}
+		}
+	}, WithClock(clock))
+	if _, err := room.StartTicker("bad", 0); err == nil {
+		t.Error("expected an error for a zero interval")
+	}
+	stop, err := room.StartTicker("loop", time.Second)
+	if err != nil {
+		t.Fatalf("StartTicker failed: %v", err)
+	}
+
+	for range 2 {
+		clock.Advance(time.Second)
+		select {
+		case name := <-ticks:
+			if name != "loop" {
+				t.Errorf("expected tick from loop, got %q", name)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected a tick")
+		}
+	}
+	stop()
+	clock.mu.Lock()
+	pending := len(clock.timers)
+	clock.mu.Unlock()
+	if pending != 0 {
+		t.Errorf("expected no pending ticks after stopping, got %d", pending)
+	}
+}
+
+func TestNilMetadata(t *testing.T) {
+	h := New(func(ctx context.Context, id string) (*testRoomMetadata, error) {
+		if id == "nil" {
+			return nil, nil
+		}
+		return &testRoomMetadata{}, nil
+	}, func(ctx context.Context, room *testRoom) {
+		<-ctx.Done()
+	})
+	if _, err := h.GetOrCreateRoom("nil"); !errors.Is(err, ErrNilMetadata) {
+		t.Errorf("expected ErrNilMetadata from init, got %v", err)
+	}
+
+	room, err := h.GetOrCreateRoom("test")
+	if err != nil {
+		t.Fatalf("GetOrCreateRoom failed: %v", err)
+	}
+	defer room.Close()
+	if _, err := room.NewClient(nil); !errors.Is(err, ErrNilMetadata) {
+		t.Errorf("expected ErrNilMetadata from NewClient, got %v", err)
+	}
+	if n := len(room.Clients()); n != 0 {
+		t.Errorf("expected no clients, got %d", n)
+	}
+
+	client := newTestClient(t, room, "alice")
+	if old := client.SwapMetadata(nil); old == nil || old.Name != "alice" {
+		t.Errorf("expected the current metadata back, got %v", old)
+	}
+	if client.Metadata() == nil {
+		t.Fatal("expected nil metadata to be rejected")
+	}
+	// Admission hooks get a copy of the metadata, so it must never be nil.
+	other, err := h.GetOrCreateRoom("other")
+	if err != nil {
+		t.Fatalf("GetOrCreateRoom failed: %v", err)
+	}
+	defer other.Close()
+	other.SetAdmission(func(*testRoom, *testClientMetadata) (bool, error) { return true, nil })
+	if err := other.AddClient(client); err != nil {
+		t.Errorf("AddClient failed: %v", err)
+	}
+}
+
+func TestDisconnectIdle(t *testing.T) {
+	events := make(chan Event[testClientMetadata, string], 10)
+	clock := &testClock{now: time.Unix(0, 0)}
+	room := newTestRoomWithHandler(t, func(ctx context.Context, room *testRoom) {
+		for event := range room.Events() {
+			if event.Type == EventLeave || event.Type == EventEmpty {
+				events <- event
+			}
+		}
+	}, WithClock(clock))
+	idle := newTestClient(t, room, "idle")
+	clock.Advance(time.Minute)
+	active := newTestClient(t, room, "active")
+
+	if n := room.DisconnectIdle(30*time.Second, ReasonKicked); n != 1 {
+		t.Fatalf("expected 1 idle client to be removed, got %d", n)
+	}
+	if event := <-events; event.Client != idle || event.Reason != ReasonKicked {
+		t.Errorf("expected idle client to leave with %v, got %v (%v)", ReasonKicked, event.Client.Metadata().Name, event.Reason)
+	}
+	if active.Context().Err() != nil {
+		t.Error("expected active client to stay connected")
+	}
+
+	clock.Advance(time.Minute)
+	if n := room.DisconnectIdle(30*time.Second, ReasonKicked); n != 1 {
+		t.Fatalf("expected 1 idle client to be removed, got %d", n)
+	}
+	<-events
+	if event := <-events; event.Type != EventEmpty {
+		t.Errorf("expected EventEmpty, got %s", event.Type)
+	}
+}
+
+type testChat struct{ Text string }
+
+func (testChat) Type() string { return "chat" }
+
+type testPing struct{}
+
+func (testPing) Type() string { return "ping" }
+
+type testPinger interface{ Ping() }
+
+func (testPing) Ping() {}
+
+func TestDispatcher(t *testing.T) {
+	var got []string
+	d := NewDispatcher[testClientMetadata, Message]()
+	On(d, func(client *Client[testClientMetadata, Message], msg testChat) {
+		got = append(got, "chat:"+msg.Text)
+	})
+	d.Default(func(client *Client[testClientMetadata, Message], data Message) {
+		got = append(got, "default:"+data.Type())
+	})
+
+	d.Dispatch(Event[testClientMetadata, Message]{Type: EventCustom, Data: testChat{Text: "hi"}})
+	d.Dispatch(Event[testClientMetadata, Message]{Type: EventCustom, Data: testPing{}})
+	if d.Dispatch(Event[testClientMetadata, Message]{Type: EventJoin}) {
+		t.Error("expected join not to be dispatched")
+	}
+	On(d, func(client *Client[testClientMetadata, Message], msg testPinger) {
+		got = append(got, "pinger")
+	})
+	d.Dispatch(Event[testClientMetadata, Message]{Type: EventCustom, Data: testPing{}})
+	d.Dispatch(Event[testClientMetadata, Message]{Type: EventCustom, Data: testChat{Text: "bye"}})
+	if fmt.Sprint(got) != "[chat:hi default:ping pinger chat:bye]" {
+		t.Errorf("unexpected dispatches %v", got)
+	}
+}
+
+func TestAutoCloseDelay(t *testing.T) {
+	room := newTestRoom(t, WithAutoCloseDelay(-1))
+	client := newTestClient(t, room, "alice")
+	room.RemoveClient(client)
+	time.Sleep(10 * time.Millisecond)
+	if room.ctx.Err() != nil {
+		t.Fatal("expected room with negative delay to stay open")
+	}
+
+	// The room has been empty for longer than the new delay already.
+	room.SetAutoCloseDelay(5 * time.Millisecond)
+	select {
+	case <-room.ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected room to close after the delay was changed")
+	}
+}
+
+func TestEventsChannelClosesWithRoom(t *testing.T) {
+	done := make(chan []EventType)
+	room := newTestRoomWithHandler(t, func(ctx context.Context, room *testRoom) {
+		var types []EventType
+		for event := range room.Events() {
+			types = append(types, event.Type)
+		}
+		done <- types
+	})
+	newTestClient(t, room, "alice")
+	room.Close()
+	room.Emit(Event[testClientMetadata, string]{Type: EventCustom})
+
+	select {
+	case types := <-done:
+		if fmt.Sprint(types) != "[EventOccupied EventJoin EventLeave]" {
+			t.Errorf("unexpected events %v", types)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected handler to return once the room closed")
+	}
+}
+
+func TestEmptyAndOccupiedEvents(t *testing.T) {
+	events := make(chan EventType, 100)
+	room := newTestRoomWithHandler(t, func(ctx context.Context, room *testRoom) {
+		for event := range room.Events() {
+			events <- event.Type
+		}
+	}, WithAutoCloseDelay(-1))
+	alice := newTestClient(t, room, "alice")
+	bob := newTestClient(t, room, "bob")
+	room.RemoveClient(alice)
+	room.RemoveClient(bob)
+	newTestClient(t, room, "carol")
+
+	var types []EventType
+	for range 8 {
+		types = append(types, receiveNext(t, events))
+	}
+	want := "[EventOccupied EventJoin EventJoin EventLeave EventLeave EventEmpty EventOccupied EventJoin]"
+	if got := fmt.Sprint(types); got != want {
+		t.Errorf("expected events %s, got %s", want, got)
+	}
+}
+
+func TestUsers(t *testing.T) {
+	events := make(chan EventType, 100)
+	room := newTestRoomWithHandler(t, func(ctx context.Context, room *testRoom) {
+		for event := range room.Events() {
+			if event.Type == EventUserJoined || event.Type == EventUserLeft {
+				events <- event.Type
+			}
+		}
+	})
+	tab1, err := room.NewClientForUser("alice", &testClientMetadata{Name: "tab1"})
+	if err != nil {
+		t.Fatalf("NewClientForUser failed: %v", err)
+	}
+	tab2, err := room.NewClientForUser("alice", &testClientMetadata{Name: "tab2"})
+	if err != nil {
+		t.Fatalf("NewClientForUser failed: %v", err)
+	}
+	newTestClient(t, room, "anonymous")
+
+	users := room.UsersInRoom()
+	if len(users) != 1 || users[0].ID != "alice" || len(users[0].Clients) != 2 || users[0].Clients[0] != tab1 {
+		t.Fatalf("expected alice with 2 clients, got %+v", users)
+	}
+	if err := room.BroadcastToUser("alice", "hi"); err != nil {
+		t.Fatalf("BroadcastToUser failed: %v", err)
+	}
+	for _, client := range []*Client[testClientMetadata, string]{tab1, tab2} {
+		if data := <-client.Receive(); data != "hi" {
+			t.Errorf("expected hi, got %q", data)
+		}
+	}
+
+	room.RemoveClient(tab1)
+	room.RemoveClient(tab2)
+	if err := room.BroadcastToUser("alice", "hi"); err == nil {
+		t.Error("expected error broadcasting to a user that left")
+	}
+	for _, want := range []EventType{EventUserJoined, EventUserLeft} {
+		select {
+		case got := <-events:
+			if got != want {
+				t.Errorf("expected %s, got %s", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected %s", want)
+		}
+	}
+}
+
+func TestBroadcastResultKeepsFailingClients(t *testing.T) {
+	// The default options would evict the client on its first full buffer.
+	room := newTestRoom(t)
+	client := newTestClient(t, room, "alice")
+
+	var failures []BroadcastError[testClientMetadata, string]
+	for i := 0; i < 1000 && len(failures) == 0; i++ {
+		failures = room.BroadcastResult("hi")
+	}
+	if len(failures) != 1 || failures[0].Client != client || !errors.Is(failures[0].Err, ErrDataDropped) {
+		t.Fatalf("expected a dropped send to alice, got %v", failures)
+	}
+	if room.ClientCount() != 1 || client.Context().Err() != nil {
+		t.Error("expected failing client to stay open in the room")
+	}
+}
+
+func TestBroadcastFuncResultKeepsFullClients(t *testing.T) {
+	room := newTestRoom(t, WithClientBuffer(2, OverflowDisconnect))
+	client := newTestClient(t, room, "alice")
+	all := func(*testClientMetadata) bool { return true }
+
+	// Nothing leaves the buffer until the consumer starts receiving.
+	for range 2 {
+		if results := room.BroadcastFuncResult(all, "hi"); len(results) != 1 || results[0].Err != nil {
+			t.Fatalf("expected a successful send, got %v", results)
+		}
+	}
+	results := room.BroadcastFuncResult(all, "hi")
+	if len(results) != 1 || results[0].Client != client || !errors.Is(results[0].Err, ErrDataDropped) {
+		t.Fatalf("expected a dropped send to alice, got %v", results)
+	}
+	if client.Context().Err() != nil {
+		t.Error("expected full client to stay connected")
+	}
+	if room.ClientCount() != 1 {
+		t.Error("expected full client to stay in the room")
+	}
+}
+
+func TestOnSendErrorKeepsFullClient(t *testing.T) {
+	for _, remove := range []bool{false, true} {
+		t.Run(fmt.Sprint("remove=", remove), func(t *testing.T) {
+			room := newTestRoom(t, WithClientBuffer(2, OverflowDisconnect))
+			client := newTestClient(t, room, "alice")
+			var got error
+			room.OnSendError(func(c *Client[testClientMetadata, string], err error) bool {
+				if c.Context().Err() != nil {
+					t.Error("expected client to still be connected in OnSendError")
+				}
+				got = err
+				return remove
+			})
+
+			// Nothing leaves the buffer until the consumer starts receiving.
+			for range 3 {
+				room.Broadcast("hi")
+			}
+			if !errors.Is(got, ErrBufferFull) {
+				t.Fatalf("expected ErrBufferFull, got %v", got)
+			}
+			if remove {
+				if cause := context.Cause(client.Context()); !errors.Is(cause, ReasonBufferFull) {
+					t.Errorf("expected client to be closed with %v, got %v", ReasonBufferFull, cause)
+				}
+				if room.ClientCount() != 0 {
+					t.Error("expected client to be removed")
+				}
+				return
+			}
+			if client.Context().Err() != nil || room.ClientCount() != 1 {
+				t.Error("expected client to be kept")
+			}
+		})
+	}
+}
+
+func TestClientBufferOverflowPolicies(t *testing.T) {
+	for _, policy := range []OverflowPolicy{OverflowDisconnect, OverflowDropNewest, OverflowDropOldest} {
+		t.Run(policy.String(), func(t *testing.T) {
+			h := New(testInit, func(ctx context.Context, room *testRoom) {
+				for range room.Events() {
+				}
+			}, WithClientBuffer(2, policy))
+			room, err := h.GetOrCreateRoom("test")
+			if err != nil {
+				t.Fatalf("GetOrCreateRoom failed: %v", err)
+			}
+			defer room.Close()
+			client := newTestClient(t, room, "alice")
+			// Nothing leaves the buffer until the consumer starts receiving.
+			for i := range 10 {
+				client.Send(fmt.Sprint(i))
+			}
+
+			if policy == OverflowDisconnect {
+				if client.Context().Err() == nil {
+					t.Error("expected client to be disconnected")
+				}
+				return
+			}
+			if client.Context().Err() != nil {
+				t.Fatal("expected client to stay connected")
+			}
+			got := fmt.Sprint(<-client.Receive(), <-client.Receive())
+			if want := map[OverflowPolicy]string{OverflowDropNewest: "01", OverflowDropOldest: "89"}[policy]; got != want {
+				t.Errorf("expected %s, got %s", want, got)
+			}
+		})
+	}
+}
+
+func TestEvictAfter(t *testing.T) {
+	room := newTestRoom(t, WithClientBuffer(2, OverflowDisconnect), WithEvictAfter(3))
+	client := newTestClient(t, room, "alice")
+	// Nothing leaves the buffer until the consumer starts receiving.
+	for i := range 4 {
+		client.Send(fmt.Sprint(i))
+	}
+	if client.Context().Err() != nil {
+		t.Fatal("expected client to survive 2 drops")
+	}
+	if n := client.DropCount(); n != 2 {
+		t.Errorf("expected 2 drops, got %d", n)
+	}
+
+	// Data getting through again resets the consecutive drops.
+	client.PurgeQueued(func(string) bool { return true })
+	for i := range 4 {
+		client.Send(fmt.Sprint(i))
+	}
+	if client.Context().Err() != nil {
+		t.Fatal("expected client to survive after its drops were reset")
+	}
+	client.Send("last straw")
+	if cause := context.Cause(client.Context()); cause != ReasonBufferFull {
+		t.Errorf("expected client to be evicted with ReasonBufferFull, got %v", cause)
+	}
+	if n := client.DropCount(); n != 5 {
+		t.Errorf("expected 5 drops, got %d", n)
+	}
+}
+
+func TestSendToClientCtxWaitsForRoom(t *testing.T) {
+	room := newTestRoom(t, WithClientBuffer(1, OverflowDisconnect))
+	client := newTestClient(t, room, "alice")
+	if err := room.SendToClient(client, "first"); err != nil {
+		t.Fatalf("SendToClient failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := room.SendToClientCtx(ctx, client, "second"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline error, got %v", err)
+	}
+	if client.Context().Err() != nil {
+		t.Fatal("expected client to stay connected")
+	}
+
+	done := make(chan error)
+	go func() { done <- room.SendToClientCtx(context.Background(), client, "second") }()
+	for _, want := range []string{"first", "second"} {
+		if got := <-client.Receive(); got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Errorf("SendToClientCtx failed: %v", err)
+	}
+}
+
+func TestClientRemovedEvent(t *testing.T) {
+	removed := make(chan Event[testClientMetadata, string], 1)
+	room := newTestRoomWithHandler(t, func(ctx context.Context, room *testRoom) {
+		for event := range room.Events() {
+			if event.Type == EventClientRemoved {
+				removed <- event
+			}
+		}
+	}, WithClientBuffer(1, OverflowDisconnect))
+	client := newTestClient(t, room, "alice")
+	room.Broadcast("first")
+	room.Broadcast("second")
+
+	select {
+	case event := <-removed:
+		if event.Client != client || event.Err == nil {
+			t.Errorf("expected alice to be removed with an error, got %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected EventClientRemoved")
+	}
+}
+
+func TestEnableHistory(t *testing.T) {
+	room := newTestRoom(t)
+	room.Broadcast("ignored")
+	if got := room.History(); len(got) != 0 {
+		t.Errorf("expected no history before EnableHistory, got %v", got)
+	}
+	room.EnableHistory(3)
+	for _, data := range []string{"a", "b", "c", "d"} {
+		room.Broadcast(data)
+	}
+	if got := fmt.Sprint(room.History()); got != "[b c d]" {
+		t.Errorf("expected history [b c d], got %s", got)
+	}
+	room.EnableHistory(2)
+	if got, seq := room.HistorySince(0); fmt.Sprint(got) != "[c d]" || seq != 4 {
+		t.Errorf("expected history [c d] at seq 4 after shrinking, got %v at seq %d", got, seq)
+	}
+	room.EnableHistory(0)
+	if got := room.History(); len(got) != 0 {
+		t.Errorf("expected no history after disabling, got %v", got)
+	}
+}
+
+func TestEnableHistoryConcurrent(t *testing.T) {
+	room := newTestRoom(t)
+	room.EnableHistory(1000)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 500 {
+			room.Broadcast(fmt.Sprint(i))
+		}
+	}()
+	for n := 1000; ; n++ {
+		select {
+		case <-done:
+			if got, seq := room.HistorySince(0); len(got) != 500 || seq != 500 {
+				t.Errorf("expected all 500 broadcasts to be recorded, got %d at seq %d", len(got), seq)
+			}
+			return
+		default:
+			room.EnableHistory(n)
+		}
+	}
+}
+
+func TestSendToMatching(t *testing.T) {
+	room := newTestRoom(t)
+	var alices []*Client[testClientMetadata, string]
+	for _, name := range []string{"alice", "bob", "alice"} {
+		client, err := room.NewClient(&testClientMetadata{Name: name})
+		if err != nil {
+			t.Fatalf("NewClient failed: %v", err)
+		}
+		if name == "alice" {
+			alices = append(alices, client)
+		}
+	}
+	isAlice := func(m *testClientMetadata) bool { return m.Name == "alice" }
+	if found := room.FindClients(isAlice); len(found) != 2 {
+		t.Errorf("expected to find 2 clients, got %d", len(found))
+	}
+	n := room.SendToMatching(isAlice, "hi alice")
+	if n != 2 {
+		t.Errorf("expected 2 clients to receive the data, got %d", n)
+	}
+	for _, client := range alices {
+		if got := <-client.Receive(); got != "hi alice" {
+			t.Errorf("expected %q, got %q", "hi alice", got)
+		}
+	}
+}
+
+func TestPresence(t *testing.T) {
+	events := make(chan Event[testClientMetadata, string], 10)
+	room := newTestRoomWithHandler(t, func(ctx context.Context, room *testRoom) {
+		for event := range room.Events() {
+			if event.Type == EventPresence {
+				events <- event
+			}
+		}
+	})
+	client := newTestClient(t, room, "alice")
+	if client.Presence() != PresenceOnline {
+		t.Errorf("expected new client to be online, got %s", client.Presence())
+	}
+	if old := client.SetPresence(PresenceAway); old != PresenceOnline {
+		t.Errorf("expected previous presence to be online, got %s", old)
+	}
+	// Setting the same presence again is not a change.
+	client.SetPresence(PresenceAway)
+	client.SetPresence(PresenceOnline)
+
+	for _, want := range [][2]Presence{{PresenceOnline, PresenceAway}, {PresenceAway, PresenceOnline}} {
+		select {
+		case event := <-events:
+			if event.Client != client || event.PrevPresence != want[0] || event.Presence != want[1] {
+				t.Errorf("expected %s -> %s, got %s -> %s", want[0], want[1], event.PrevPresence, event.Presence)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected EventPresence")
+		}
+	}
+	select {
+	case event := <-events:
+		t.Errorf("unexpected event %s -> %s", event.PrevPresence, event.Presence)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestUpdateMetadata(t *testing.T) {
+	type repoMetadata struct {
+		Commit string
+	}
+	changed := make(chan struct{}, 1)
+	h := New(func(ctx context.Context, id string) (*repoMetadata, error) {
+		return &repoMetadata{Commit: "a"}, nil
+	}, func(ctx context.Context, room *Room[repoMetadata, testClientMetadata, string]) {
+		for event := range room.Events() {
+			if event.Type == EventRoomMetadataChanged {
+				changed <- struct{}{}
+			}
+		}
+	})
+	room, err := h.GetOrCreateRoom("test")
+	if err != nil {
+		t.Fatalf("GetOrCreateRoom failed: %v", err)
+	}
+	defer room.Close()
+	metadata := room.Metadata()
+	room.UpdateMetadata(func(metadata *repoMetadata) {
+		metadata.Commit = "b"
+	})
+	if room.Metadata() != metadata || metadata.Commit != "b" {
+		t.Errorf("expected commit to be updated in place, got %s", room.Metadata().Commit)
+	}
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("expected EventRoomMetadataChanged")
+	}
+}
+
+func TestUpdateMetadataBeforeInit(t *testing.T) {
+	gate := make(chan struct{})
+	h := New(func(ctx context.Context, id string) (*testRoomMetadata, error) {
+		<-gate
+		return &testRoomMetadata{}, nil
+	}, func(ctx context.Context, room *testRoom) {
+		<-ctx.Done()
+	})
+	room := newRoom(h, "test", h.init, h.handler)
+	defer room.Close()
+	defer close(gate)
+
+	room.UpdateMetadata(func(*testRoomMetadata) {
+		t.Error("expected update not to run without metadata")
+	})
+	err := room.UpdateAndBroadcast(func(*testRoomMetadata) (string, bool) {
+		t.Error("expected update not to run without metadata")
+		return "", false
+	})
+	if err != nil {
+		t.Errorf("UpdateAndBroadcast failed: %v", err)
+	}
+}
+
+func TestMaxClients(t *testing.T) {
+	room := newTestRoom(t, WithMaxClients(5))
+	room.SetMaxClients(2)
+
+	var wg sync.WaitGroup
+	var joined, full atomic.Int32
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := room.NewClient(&testClientMetadata{Name: "alice"})
+			switch {
+			case err == nil:
+				joined.Add(1)
+			case errors.Is(err, ErrRoomFull):
+				full.Add(1)
+			default:
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if joined.Load() != 2 || full.Load() != 8 {
+		t.Errorf("expected 2 clients to join and 8 to be refused, got %d and %d", joined.Load(), full.Load())
+	}
+	if n := room.ClientCount(); n != 2 {
+		t.Errorf("expected 2 clients, got %d", n)
+	}
+}
+
+func TestSendToClientBytes(t *testing.T) {
+	room := newTestMessageRoom(t, func(ctx context.Context, room *testMessageRoom) {
+		for range room.Events() {
+		}
+	})
+	alice, err := room.NewClient(&testClientMetadata{Name: "alice"})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	bob, err := room.NewClient(&testClientMetadata{Name: "bob"})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	aliceBytes := alice.ReceiveBytes()
+	bobData := bob.Receive()
+
+	raw := []byte(`chat {"content":"raw"}`)
+	if err := room.SendToClientBytes(alice, raw); err != nil {
+		t.Fatalf("SendToClientBytes failed: %v", err)
+	}
+	if got := receiveNext(t, aliceBytes); string(got) != string(raw) {
+		t.Errorf("expected raw bytes to pass through, got %q", got)
+	}
+	if err := room.SendToClient(alice, &testChatMessage{Content: "typed"}); err != nil {
+		t.Fatalf("SendToClient failed: %v", err)
+	}
+	if got := receiveNext(t, aliceBytes); string(got) != `chat {"content":"typed"}` {
+		t.Errorf("expected typed data to be encoded, got %q", got)
+	}
+
+	if err := room.SendToClientBytes(bob, raw); err != nil {
+		t.Fatalf("SendToClientBytes failed: %v", err)
+	}
+	if msg, ok := receiveNext(t, bobData).(*testChatMessage); !ok || msg.Content != "raw" {
+		t.Errorf("expected raw bytes to be decoded, got %#v", msg)
+	}
+
+	plain := newTestRoom(t)
+	client := newTestClient(t, plain, "carol")
+	if err := plain.SendToClientBytes(client, raw); err == nil {
+		t.Error("expected an error sending bytes without a codec")
+	}
+}
+
+func TestBroadcastJSON(t *testing.T) {
+	room := newTestMessageRoom(t, func(ctx context.Context, room *testMessageRoom) {
+		for range room.Events() {
+		}
+	})
+	client, err := room.NewClient(&testClientMetadata{Name: "alice"})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	received := client.ReceiveBytes()
+	type notice struct {
+		Text string `json:"text"`
+	}
+	if err := room.BroadcastJSON("notice", notice{Text: "restarting"}); err != nil {
+		t.Fatalf("BroadcastJSON failed: %v", err)
+	}
+	if got := receiveNext(t, received); string(got) != `notice {"text":"restarting"}` {
+		t.Errorf("expected an unregistered type to be framed as JSON, got %q", got)
+	}
+
+	plain := newTestRoom(t)
+	if err := plain.BroadcastJSON("notice", notice{}); err == nil {
+		t.Error("expected an error without a JSON codec")
+	}
+}
+
+func TestHandleClientBytesDecodeError(t *testing.T) {
+	events := make(chan Event[testClientMetadata, Message], 10)
+	room := newTestMessageRoom(t, func(ctx context.Context, room *testMessageRoom) {
+		for event := range room.Events() {
+			if event.Type == EventDecodeError {
+				events <- event
+			}
+		}
+	})
+	client, err := room.NewClient(&testClientMetadata{Name: "alice"})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	raw := []byte("garbage\x00")
+	err = room.HandleClientBytes(client, raw)
+	if err == nil {
+		t.Fatal("expected an error for a bad frame")
+	}
+	event := receiveNext(t, events)
+	if event.Client != client || string(event.Raw) != string(raw) || event.Err == nil {
+		t.Fatalf("expected the event to carry the client, bytes and error, got %+v", event)
+	}
+	if !errors.Is(err, event.Err) {
+		t.Errorf("expected the returned error to wrap %v, got %v", event.Err, err)
+	}
+	if !strings.Contains(err.Error(), `"garbage\x00"`) {
+		t.Errorf("expected the bad frame to be quoted in %q", err)
+	}
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	events := make(chan Event[testClientMetadata, Message], 10)
+	room := newTestMessageRoom(t, func(ctx context.Context, room *testMessageRoom) {
+		for event := range room.Events() {
+			if event.Type == EventDecodeError || event.Type == EventCustom {
+				events <- event
+			}
+		}
+	}, WithMaxMessageSize(1024))
+	client, err := room.NewClient(&testClientMetadata{Name: "alice"})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	// A small frame that inflates to far more than the limit.
+	registry := MessageRegistry[Message]{}
+	registry.Register(&testChatMessage{})
+	bomb, err := NewJSONCodec(registry, WithCompression(CompressionGzip, 0)).Encode(&testChatMessage{Content: strings.Repeat("a", 1<<18)})
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if len(bomb) > 1024 {
+		t.Fatalf("expected the compressed frame to fit the limit, got %d bytes", len(bomb))
+	}
+	for _, frame := range [][]byte{bytes.Repeat([]byte("a"), 1025), bomb} {
+		if err := room.HandleClientBytes(client, frame); !errors.Is(err, ErrMessageTooLarge) {
+			t.Errorf("expected ErrMessageTooLarge, got %v", err)
+		}
+		if event := receiveNext(t, events); event.Type != EventDecodeError || !errors.Is(event.Err, ErrMessageTooLarge) {
+			t.Errorf("expected a decode error for the oversized frame, got %s with %v", event.Type, event.Err)
+		}
+	}
+	if err := room.HandleClientBytes(client, []byte(`chat {"content":"hi"}`)); err != nil {
+		t.Errorf("expected a small frame to be accepted, got %v", err)
+	}
+	if event := receiveNext(t, events); event.Type != EventCustom {
+		t.Errorf("expected EventCustom, got %s", event.Type)
+	}
+}
+
+func TestClientRateLimit(t *testing.T) {
+	room := newTestRoom(t, WithClientRateLimit(1, 3))
+	alice := newTestClient(t, room, "alice")
+	bob := newTestClient(t, room, "bob")
+	for i := range 3 {
+		if err := room.HandleClientData(alice, "spam"); err != nil {
+			t.Fatalf("message %d: HandleClientData failed: %v", i, err)
+		}
+	}
+	if err := room.HandleClientData(alice, "spam"); !errors.Is(err, ErrClientRateLimited) {
+		t.Errorf("expected ErrClientRateLimited, got %v", err)
+	}
+	if err := room.HandleClientData(bob, "hi"); err != nil {
+		t.Errorf("expected other clients not to be limited, got %v", err)
+	}
+}
+
+func TestEventOverflowDrop(t *testing.T) {
+	gate := make(chan struct{})
+	dropped := make(chan uint64, 1)
+	room := newTestRoomWithHandler(t, func(ctx context.Context, room *testRoom) {
+		<-gate
+		for event := range room.Events() {
+			if event.Type == EventDropped {
+				dropped <- event.Dropped
+			}
+		}
+	}, WithEventOverflow(EventOverflowDrop))
+	// Subscribers see EventDropped like any other event.
+	events, unsubscribe := room.Subscribe(cap(room.eventsCh)+10, DropOldest)
+	defer unsubscribe()
+	for room.EventBacklog() < cap(room.eventsCh) {
+		room.Emit(Event[testClientMetadata, string]{Type: EventCustom})
+	}
+	for range 5 {
+		room.Emit(Event[testClientMetadata, string]{Type: EventCustom})
+	}
+	if room.closed.Load() {
+		t.Fatal("expected room to stay open when dropping events")
+	}
+	// The handler is told about the drops once it catches up, without any
+	// further events being emitted.
+	close(gate)
+	select {
+	case n := <-dropped:
+		if n != 5 {
+			t.Errorf("expected 5 dropped events, got %d", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected EventDropped")
+	}
+	for seen := false; !seen; {
+		select {
+		case event := <-events:
+			seen = event.Type == EventDropped
+		case <-time.After(time.Second):
+			t.Fatal("expected subscribers to see EventDropped")
+		}
+	}
+}
+
+func TestEventOverflowBlock(t *testing.T) {
+	gate := make(chan struct{})
+	room := newTestRoomWithHandler(t, func(ctx context.Context, room *testRoom) {
+		<-gate
+		for range room.Events() {
+		}
+	}, WithEventOverflow(EventOverflowBlock))
+	for room.EventBacklog() < cap(room.eventsCh) {
+		room.Emit(Event[testClientMetadata, string]{Type: EventCustom})
+	}
+	done := make(chan struct{})
+	go func() {
+		room.Emit(Event[testClientMetadata, string]{Type: EventCustom})
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("expected Emit to block while the channel is full")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(gate)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected Emit to finish once the handler reads")
+	}
+	if room.closed.Load() {
+		t.Error("expected room to stay open when blocking")
+	}
+}
+
+func TestEventOverflowBlockHandlerEmits(t *testing.T) {
+	clock := &testClock{now: time.Unix(0, 0)}
+	gate := make(chan struct{})
+	updated := make(chan struct{})
+	dropped := make(chan uint64, 1)
+	room := newTestRoomWithHandler(t, func(ctx context.Context, room *testRoom) {
+		<-gate
+		// The channel is full, so this would wait for the handler itself.
+		room.UpdateMetadata(func(*testRoomMetadata) {})
+		close(updated)
+		for event := range room.Events() {
+			if event.Type == EventDropped {
+				dropped <- event.Dropped
+			}
+		}
+	}, WithEventOverflow(EventOverflowBlock), WithClock(clock))
+	for room.EventBacklog() < cap(room.eventsCh) {
+		room.Emit(Event[testClientMetadata, string]{Type: EventCustom})
+	}
+	close(gate)
+	deadline := time.After(time.Second)
+	for waiting := true; waiting; {
+		clock.Advance(eventBlockTimeout)
+		select {
+		case <-updated:
+			waiting = false
+		case <-time.After(time.Millisecond):
+		case <-deadline:
+			t.Fatal("expected the handler's emit to time out")
+		}
+	}
+	select {
+	case n := <-dropped:
+		if n != 1 {
+			t.Errorf("expected 1 dropped event, got %d", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected EventDropped")
+	}
+	if room.closed.Load() {
+		t.Error("expected room to stay open")
+	}
+}
+
+func TestClientSend(t *testing.T) {
+	room := newTestRoom(t)
+	client := newTestClient(t, room, "alice")
+	if err := client.Send("hello"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if got := <-client.Receive(); got != "hello" {
+		t.Errorf("expected %q, got %q", "hello", got)
+	}
+	client.Close()
+	if err := client.Send("bye"); err == nil {
+		t.Error("expected Send to a closed client to fail")
+	}
+}
+
+// testClock is a fake Clock that only moves when advanced, firing due timers
+// synchronously.
+type testClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*testTimer
+}
+
+type testTimer struct {
+	clock *testClock
+	at    time.Time
+	f     func()
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &testTimer{clock: c, at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	var due []*testTimer
+	c.timers = slices.DeleteFunc(c.timers, func(t *testTimer) bool {
+		if t.at.After(c.now) {
+			return false
+		}
+		due = append(due, t)
+		return true
+	})
+	c.mu.Unlock()
+	for _, t := range due {
+		t.f()
+	}
+}
+
+func (t *testTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	n := len(t.clock.timers)
+	t.clock.timers = slices.DeleteFunc(t.clock.timers, func(other *testTimer) bool { return other == t })
+	return len(t.clock.timers) < n
+}
+
+func TestAutoCloseWithClock(t *testing.T) {
+	clock := &testClock{now: time.Unix(0, 0)}
+	room := newTestRoom(t, WithClock(clock))
+	client := newTestClient(t, room, "alice")
+	room.RemoveClient(client)
+
+	clock.Advance(DefaultAutoCloseDelay - time.Second)
+	if room.ctx.Err() != nil {
+		t.Fatal("expected room to stay open before the delay has passed")
+	}
+	clock.Advance(time.Second)
+	if room.ctx.Err() == nil {
+		t.Fatal("expected room to close once the delay has passed")
+	}
+}
+
+func TestSetCanAutoClose(t *testing.T) {
+	clock := &testClock{now: time.Unix(0, 0)}
+	room := newTestRoom(t, WithClock(clock))
+	var allow atomic.Bool
+	var calls atomic.Int32
+	room.SetCanAutoClose(func(*testRoom) bool {
+		calls.Add(1)
+		return allow.Load()
+	})
+	client := newTestClient(t, room, "alice")
+	room.RemoveClient(client)
+
+	clock.Advance(DefaultAutoCloseDelay)
+	if room.ctx.Err() != nil {
+		t.Fatal("expected room to stay open while it can't auto-close")
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected 1 call, got %d", n)
+	}
+	allow.Store(true)
+	clock.Advance(DefaultAutoCloseDelay)
+	if room.ctx.Err() == nil {
+		t.Fatal("expected room to close once it was allowed to")
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("expected 2 calls, got %d", n)
+	}
+}
+
+func TestSendLatency(t *testing.T) {
+	room := newTestRoom(t, WithLatencyTracking())
+	client := newTestClient(t, room, "alice")
+	for i := range 3 {
+		client.Send(fmt.Sprint(i))
+	}
+	time.Sleep(20 * time.Millisecond)
+	received := client.Receive()
+	for range 3 {
+		receiveNext(t, received)
+	}
+
+	// Delivery is recorded right after the consumer has read the data.
+	var stats LatencyStats
+	deadline := time.Now().Add(time.Second)
+	for stats = client.SendLatency(); stats.Count < 3; stats = client.SendLatency() {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 3 samples, got %d", stats.Count)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if stats.Count != 3 {
+		t.Errorf("expected 3 samples, got %d", stats.Count)
+	}
+	if stats.P50 < 20*time.Millisecond || stats.Max < stats.P50 {
+		t.Errorf("expected samples of at least 20ms, got P50 %v and max %v", stats.P50, stats.Max)
+	}
+
+	untracked := newTestClient(t, newTestRoom(t), "bob")
+	if stats := untracked.SendLatency(); stats != (LatencyStats{}) {
+		t.Errorf("expected no stats without latency tracking, got %+v", stats)
+	}
+}
+
+func TestClientID(t *testing.T) {
+	room := newTestRoom(t)
+	ids := make(map[string]bool)
+	for range 3 {
+		client := newTestClient(t, room, "alice")
+		if client.ID() == "" || ids[client.ID()] {
+			t.Errorf("expected a new unique ID, got %q", client.ID())
+		}
+		if info := client.Debug(); info.ID != client.ID() {
+			t.Errorf("expected debug info to have ID %q, got %q", client.ID(), info.ID)
+		}
+		ids[client.ID()] = true
+	}
+}
+
+func TestDiagnosticEvents(t *testing.T) {
+	if ch := newTestRoom(t).DiagnosticEvents(); ch != nil {
+		t.Fatal("expected no diagnostic events without WithEventDiagnostics")
+	}
+
+	handled := make(chan string, 3)
+	room := newTestRoomWithHandler(t, func(ctx context.Context, room *testRoom) {
+		for event := range room.Events() {
+			handled <- event.Data
+		}
+	}, WithEventDiagnostics(2))
+
+	// Nothing reads the diagnostics channel yet, so the third event can't be
+	// mirrored. Emit must not block on it.
+	done := make(chan struct{})
+	go func() {
+		for _, data := range []string{"a", "b", "c"} {
+			room.Emit(Event[testClientMetadata, string]{Type: EventCustom, Data: data})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Emit blocked on the diagnostics channel")
+	}
+
+	for _, want := range []string{"a", "b", "c"} {
+		if got := receiveNext(t, handled); got != want {
+			t.Errorf("expected handler to receive %q, got %q", want, got)
+		}
+	}
+	for _, want := range []string{"a", "b"} {
+		event := receiveNext(t, room.DiagnosticEvents())
+		if event.Type != EventCustom || event.Data != want {
+			t.Errorf("expected mirrored %s %q, got %s %q", EventCustom, want, event.Type, event.Data)
+		}
+	}
+	select {
+	case event := <-room.DiagnosticEvents():
+		t.Errorf("expected the third event to be dropped, got %q", event.Data)
+	default:
+	}
+}
@@ -0,0 +1,51 @@
+package hotel
+
+import (
+	"fmt"
+	"time"
+)
+
+// ErrorCategory classifies a RoomError.
+type ErrorCategory string
+
+const (
+	// ErrorOverflow means the room's events channel was full. Depending on
+	// WithEventOverflow, the room was closed or the event was dropped.
+	ErrorOverflow ErrorCategory = "overflow"
+	// ErrorSendFailure means data couldn't be sent to a client.
+	ErrorSendFailure ErrorCategory = "send_failure"
+	// ErrorPanic means the room's init or handler panicked.
+	ErrorPanic ErrorCategory = "panic"
+)
+
+// RoomError is an internal failure that happened in a room.
+type RoomError struct {
+	Category ErrorCategory
+	Err      error
+	Time     time.Time
+}
+
+func (e RoomError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Category, e.Err)
+}
+
+func (e RoomError) Unwrap() error {
+	return e.Err
+}
+
+// LastError returns the most recent internal failure in the room, e.g. for a
+// health dashboard, and false if nothing has failed.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) LastError() (RoomError, bool) {
+	if err := r.lastError.Load(); err != nil {
+		return *err, true
+	}
+	return RoomError{}, false
+}
+
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) recordError(category ErrorCategory, err error) {
+	r.lastError.Store(&RoomError{
+		Category: category,
+		Err:      err,
+		Time:     time.Now(),
+	})
+}
@@ -0,0 +1,99 @@
+package hotel
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Observer is notified about the hotel's operation, e.g. to record metrics.
+// Its methods are called synchronously, so they should return quickly.
+type Observer interface {
+	// InitCompleted is called when a room's init function has returned (or
+	// panicked), with how long it took and the error it failed with, if any.
+	InitCompleted(roomID string, duration time.Duration, err error)
+}
+
+// EventObserver can be implemented by an Observer to also be notified about
+// the events that handlers track with Room.TrackEvent.
+type EventObserver interface {
+	// EventProcessed is called with how long the event waited before the
+	// handler read it, and how long the handler then took to process it.
+	EventProcessed(roomID string, eventType EventType, queueWait, handlerDuration time.Duration)
+}
+
+// MetricsObserver can be implemented by an Observer to also be notified
+// about rooms and clients coming and going and about the rooms' event
+// channels, e.g. to back Prometheus gauges and counters.
+type MetricsObserver interface {
+	// RoomCreated and RoomClosed are called when a room in the hotel has
+	// finished its init successfully, and when it has closed.
+	RoomCreated(roomID string)
+	RoomClosed(roomID string)
+	// ClientJoined and ClientLeft are called when a client joins or leaves
+	// a room, including the lobby.
+	ClientJoined(roomID string)
+	ClientLeft(roomID string, reason LeaveReason)
+	// EventDropped is called when an event couldn't be queued for a room's
+	// handler because its channel was full. Whether the room is then closed
+	// or the event is just dropped depends on WithEventOverflow.
+	EventDropped(roomID string, eventType EventType)
+	// EventChannelDepth is called after each event is queued for a room's
+	// handler, with the number of events waiting in its channel.
+	EventChannelDepth(roomID string, n int)
+}
+
+// HotelStats summarizes the hotel's operation since it was created.
+type HotelStats struct {
+	Inits      uint64
+	InitErrors uint64
+	// InitDuration summarizes how long recent room inits took.
+	InitDuration LatencyStats
+}
+
+// hotelStats collects the counters behind HotelStats.
+type hotelStats struct {
+	inits        atomic.Uint64
+	initErrors   atomic.Uint64
+	initDuration latencyRecorder
+}
+
+// Stats returns a summary of the hotel's operation since it was created.
+func (h *Hotel[RoomMetadata, ClientMetadata, DataType]) Stats() HotelStats {
+	return HotelStats{
+		Inits:        h.stats.inits.Load(),
+		InitErrors:   h.stats.initErrors.Load(),
+		InitDuration: h.stats.initDuration.stats(),
+	}
+}
+
+func (h *Hotel[RoomMetadata, ClientMetadata, DataType]) recordInit(roomID string, duration time.Duration, err error) {
+	h.stats.inits.Add(1)
+	if err != nil {
+		h.stats.initErrors.Add(1)
+	}
+	h.stats.initDuration.record(duration)
+	if h.opts.observer != nil {
+		h.opts.observer.InitCompleted(roomID, duration, err)
+	}
+}
+
+// TrackEvent measures how long the handler takes to process an event it just
+// read, for the hotel's EventObserver, if any. Call it right after reading the
+// event and call the returned function once done with it:
+//
+//	for event := range room.Events() {
+//		done := room.TrackEvent(event)
+//		// ...
+//		done()
+//	}
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) TrackEvent(event Event[ClientMetadata, DataType]) (done func()) {
+	observer, ok := r.hotel.opts.observer.(EventObserver)
+	if !ok {
+		return func() {}
+	}
+	start := time.Now()
+	queueWait := start.Sub(event.Emitted)
+	return func() {
+		observer.EventProcessed(r.ID(), event.Type, queueWait, time.Since(start))
+	}
+}
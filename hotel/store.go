@@ -0,0 +1,91 @@
+package hotel
+
+import (
+	"hash/maphash"
+	"sync"
+)
+
+// RoomStore keeps track of the rooms in a hotel, e.g. to consult a directory
+// of which rooms live on which node. The rooms themselves always live in the
+// process. A store must be safe for concurrent use, but the hotel never
+// changes the same room ID from two goroutines at once.
+type RoomStore[RoomMetadata, ClientMetadata, DataType any] interface {
+	Get(id string) (*Room[RoomMetadata, ClientMetadata, DataType], bool)
+	Put(id string, room *Room[RoomMetadata, ClientMetadata, DataType])
+	Delete(id string)
+	// Range calls fn for every room in the store until fn returns false.
+	Range(fn func(id string, room *Room[RoomMetadata, ClientMetadata, DataType]) bool)
+}
+
+// roomShard holds a subset of the memory store's rooms, so that lookups of
+// rooms in different shards don't contend on the same lock.
+type roomShard[RoomMetadata, ClientMetadata, DataType any] struct {
+	mu    sync.RWMutex
+	rooms map[string]*Room[RoomMetadata, ClientMetadata, DataType]
+}
+
+// memoryStore is the default RoomStore, which keeps rooms in sharded maps.
+type memoryStore[RoomMetadata, ClientMetadata, DataType any] struct {
+	shards []roomShard[RoomMetadata, ClientMetadata, DataType]
+	seed   maphash.Seed
+}
+
+func newMemoryStore[RoomMetadata, ClientMetadata, DataType any](shards int) *memoryStore[RoomMetadata, ClientMetadata, DataType] {
+	s := &memoryStore[RoomMetadata, ClientMetadata, DataType]{
+		shards: make([]roomShard[RoomMetadata, ClientMetadata, DataType], max(1, shards)),
+		seed:   maphash.MakeSeed(),
+	}
+	for i := range s.shards {
+		s.shards[i].rooms = make(map[string]*Room[RoomMetadata, ClientMetadata, DataType])
+	}
+	return s
+}
+
+func (s *memoryStore[RoomMetadata, ClientMetadata, DataType]) Get(id string) (*Room[RoomMetadata, ClientMetadata, DataType], bool) {
+	shard := s.shard(id)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	room, ok := shard.rooms[id]
+	return room, ok
+}
+
+func (s *memoryStore[RoomMetadata, ClientMetadata, DataType]) Put(id string, room *Room[RoomMetadata, ClientMetadata, DataType]) {
+	shard := s.shard(id)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	shard.rooms[id] = room
+}
+
+func (s *memoryStore[RoomMetadata, ClientMetadata, DataType]) Delete(id string) {
+	shard := s.shard(id)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	delete(shard.rooms, id)
+}
+
+// Range calls fn for a snapshot of each shard, so that fn can use the store.
+func (s *memoryStore[RoomMetadata, ClientMetadata, DataType]) Range(fn func(id string, room *Room[RoomMetadata, ClientMetadata, DataType]) bool) {
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.mu.RLock()
+		ids := make([]string, 0, len(shard.rooms))
+		rooms := make([]*Room[RoomMetadata, ClientMetadata, DataType], 0, len(shard.rooms))
+		for id, room := range shard.rooms {
+			ids = append(ids, id)
+			rooms = append(rooms, room)
+		}
+		shard.mu.RUnlock()
+		for j, id := range ids {
+			if !fn(id, rooms[j]) {
+				return
+			}
+		}
+	}
+}
+
+func (s *memoryStore[RoomMetadata, ClientMetadata, DataType]) shard(id string) *roomShard[RoomMetadata, ClientMetadata, DataType] {
+	if len(s.shards) == 1 {
+		return &s.shards[0]
+	}
+	return &s.shards[maphash.String(s.seed, id)%uint64(len(s.shards))]
+}
@@ -0,0 +1,116 @@
+package hotel
+
+import (
+	"fmt"
+	"sync"
+)
+
+// DropPolicy decides which event is lost when a subscriber falls behind.
+type DropPolicy int
+
+const (
+	// DropNewest discards the event being emitted, keeping what's buffered.
+	DropNewest DropPolicy = iota
+	// DropOldest discards the oldest buffered event to make room for the new
+	// one.
+	DropOldest
+)
+
+func (p DropPolicy) String() string {
+	switch p {
+	case DropNewest:
+		return "DropNewest"
+	case DropOldest:
+		return "DropOldest"
+	}
+	return fmt.Sprintf("<!DropPolicy %d>", p)
+}
+
+type subscriber[ClientMetadata, DataType any] struct {
+	ch     chan Event[ClientMetadata, DataType]
+	onFull DropPolicy
+}
+
+// subscribers is the set of event mirrors for a room. Sends happen under the
+// read lock and closing under the write lock, so an event is never sent on a
+// closed channel.
+type subscribers[ClientMetadata, DataType any] struct {
+	mu     sync.RWMutex
+	subs   map[*subscriber[ClientMetadata, DataType]]struct{}
+	closed bool
+}
+
+func (s *subscribers[ClientMetadata, DataType]) add(bufferSize int, onFull DropPolicy) *subscriber[ClientMetadata, DataType] {
+	sub := &subscriber[ClientMetadata, DataType]{
+		ch:     make(chan Event[ClientMetadata, DataType], bufferSize),
+		onFull: onFull,
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		close(sub.ch)
+		return sub
+	}
+	if s.subs == nil {
+		s.subs = make(map[*subscriber[ClientMetadata, DataType]]struct{})
+	}
+	s.subs[sub] = struct{}{}
+	return sub
+}
+
+func (s *subscribers[ClientMetadata, DataType]) remove(sub *subscriber[ClientMetadata, DataType]) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subs[sub]; ok {
+		delete(s.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// send never blocks; events that don't fit are dropped per each subscriber's
+// policy.
+func (s *subscribers[ClientMetadata, DataType]) send(event Event[ClientMetadata, DataType]) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for sub := range s.subs {
+		for {
+			select {
+			case sub.ch <- event:
+			default:
+				if sub.onFull == DropOldest && cap(sub.ch) > 0 {
+					select {
+					case <-sub.ch:
+					default:
+					}
+					// Another emitter may have filled the freed slot, in
+					// which case we try again.
+					continue
+				}
+			}
+			break
+		}
+	}
+}
+
+// closeAll closes every subscriber's channel, as well as the channels of
+// subscribers that are added later.
+func (s *subscribers[ClientMetadata, DataType]) closeAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for sub := range s.subs {
+		close(sub.ch)
+	}
+	s.subs = nil
+	s.closed = true
+}
+
+// Subscribe returns a channel that mirrors every event emitted in the room,
+// for observers such as persistence writers or analytics that must never slow
+// down the handler. Events that don't fit in the buffer are dropped according
+// to onFull, so a slow subscriber never blocks or closes the room. Call the
+// returned function to unsubscribe. The channel is closed on unsubscribe or
+// when the room closes.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) Subscribe(bufferSize int, onFull DropPolicy) (<-chan Event[ClientMetadata, DataType], func()) {
+	sub := r.subscribers.add(bufferSize, onFull)
+	return sub.ch, func() { r.subscribers.remove(sub) }
+}
@@ -0,0 +1,78 @@
+package hotel
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// StartTicker emits an EventTick with the given name to the room's handler
+// every interval, as measured by the hotel's Clock, until the returned
+// function is called or the room closes. Starting a ticker with the name of a
+// running ticker replaces it. Like a time.Ticker, it skips ticks while the
+// handler is behind, here meaning that the events channel is over half full,
+// so ticks alone never overflow it. The interval must be positive.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) StartTicker(name string, interval time.Duration) (stop func(), err error) {
+	if interval <= 0 {
+		return nil, errors.New("non-positive interval for ticker")
+	}
+	ctx, cancel := context.WithCancel(r.ctx)
+	t := &roomTicker{cancel: cancel}
+	r.tickersMu.Lock()
+	if r.tickers == nil {
+		r.tickers = make(map[string]*roomTicker)
+	}
+	if prev, ok := r.tickers[name]; ok {
+		prev.stop()
+	}
+	r.tickers[name] = t
+	r.tickersMu.Unlock()
+
+	var tick func()
+	tick = func() {
+		t.mu.Lock()
+		if ctx.Err() != nil {
+			t.mu.Unlock()
+			return
+		}
+		t.timer = r.hotel.clock.AfterFunc(interval, tick)
+		t.mu.Unlock()
+		if len(r.eventsCh) > cap(r.eventsCh)/2 {
+			return
+		}
+		r.Emit(Event[ClientMetadata, DataType]{
+			Type:   EventTick,
+			Ticker: name,
+		})
+	}
+	t.mu.Lock()
+	t.timer = r.hotel.clock.AfterFunc(interval, tick)
+	t.mu.Unlock()
+	// Closing the room stops the pending timer too.
+	context.AfterFunc(ctx, t.stop)
+
+	return func() {
+		t.stop()
+		r.tickersMu.Lock()
+		// The ticker may have been replaced by one with the same name.
+		if r.tickers[name] == t {
+			delete(r.tickers, name)
+		}
+		r.tickersMu.Unlock()
+	}, nil
+}
+
+type roomTicker struct {
+	cancel context.CancelFunc
+
+	mu    sync.Mutex
+	timer Timer // The timer for the next tick.
+}
+
+func (t *roomTicker) stop() {
+	t.cancel()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.timer.Stop()
+}
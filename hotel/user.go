@@ -0,0 +1,114 @@
+package hotel
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// User is one logical participant in a room, backed by one or more clients,
+// e.g. one per browser tab a person has open.
+type User[ClientMetadata, DataType any] struct {
+	ID string
+	// Clients are the user's clients in the room, in join order.
+	Clients []*Client[ClientMetadata, DataType]
+}
+
+// NewClientForUser is like NewClient, but associates the client with the
+// given application-supplied user ID, typically from authentication. A user's
+// clients are grouped by UsersInRoom and reached together by BroadcastToUser,
+// and the room's handler gets an EventUserJoined when the user's first client
+// joins and an EventUserLeft when the last one leaves, in addition to each
+// client's EventJoin and EventLeave.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) NewClientForUser(userID string, metadata *ClientMetadata) (*Client[ClientMetadata, DataType], error) {
+	if userID == "" {
+		return nil, errors.New("cannot create client: user ID cannot be empty")
+	}
+	return r.newRoomClient(userID, metadata)
+}
+
+// UserID returns the ID of the user the client belongs to, or an empty string
+// if it wasn't created with NewClientForUser.
+func (c *Client[ClientMetadata, DataType]) UserID() string {
+	return c.userID
+}
+
+// UsersInRoom returns the distinct users in the room, sorted by ID. Clients
+// that don't belong to a user are not included.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) UsersInRoom() []User[ClientMetadata, DataType] {
+	r.mu.RLock()
+	clients := r.clients
+	r.mu.RUnlock()
+	var members []*Client[ClientMetadata, DataType]
+	for client := range clients {
+		if client.userID != "" {
+			members = append(members, client)
+		}
+	}
+	slices.SortFunc(members, func(a, b *Client[ClientMetadata, DataType]) int {
+		return cmp.Compare(clients[a], clients[b])
+	})
+	byID := make(map[string]int)
+	var users []User[ClientMetadata, DataType]
+	for _, client := range members {
+		i, ok := byID[client.userID]
+		if !ok {
+			i = len(users)
+			byID[client.userID] = i
+			users = append(users, User[ClientMetadata, DataType]{ID: client.userID})
+		}
+		users[i].Clients = append(users[i].Clients, client)
+	}
+	slices.SortFunc(users, func(a, b User[ClientMetadata, DataType]) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return users
+}
+
+// BroadcastToUser sends data to every client of the user in the room, like
+// SendToClient, and returns the errors for the clients it couldn't send to.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) BroadcastToUser(userID string, data DataType) error {
+	var errs []error
+	found := false
+	for _, client := range r.Clients() {
+		if client.userID != userID || userID == "" {
+			continue
+		}
+		found = true
+		if err := r.SendToClient(client, data); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if !found {
+		return fmt.Errorf("user %q is not in the room", userID)
+	}
+	return errors.Join(errs...)
+}
+
+// addUserClient counts the client towards its user, and reports whether it's
+// the user's first client in the room. r.mu must be held for writing.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) addUserClient(client *Client[ClientMetadata, DataType]) bool {
+	if client.userID == "" {
+		return false
+	}
+	if r.users == nil {
+		r.users = make(map[string]int)
+	}
+	r.users[client.userID]++
+	return r.users[client.userID] == 1
+}
+
+// removeUserClient stops counting the client towards its user, and reports
+// whether it was the user's last client in the room. r.mu must be held for
+// writing.
+func (r *Room[RoomMetadata, ClientMetadata, DataType]) removeUserClient(client *Client[ClientMetadata, DataType]) bool {
+	if client.userID == "" {
+		return false
+	}
+	if r.users[client.userID]--; r.users[client.userID] > 0 {
+		return false
+	}
+	delete(r.users, client.userID)
+	return true
+}
@@ -2,8 +2,6 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
 	"log"
 	"net/http"
 	"strings"
@@ -48,8 +46,11 @@ func (m ChatMessage) Type() string {
 	return "chat"
 }
 
+// Maximum size of an inbound message in bytes
+const maxMessageSize = 64 << 10
+
 // Global room manager instance
-var roomManager = hotel.New(roomInit, roomHandler)
+var roomManager = hotel.New(roomInit, roomHandler, hotel.WithCodec(codec), hotel.WithMaxMessageSize(maxMessageSize))
 
 // WebSocket connection upgrader
 var upgrader = websocket.Upgrader{
@@ -61,6 +62,9 @@ var upgrader = websocket.Upgrader{
 // Message registry for type handling
 var messageRegistry = hotel.MessageRegistry[hotel.Message]{}
 
+// Codec for converting messages to and from WebSocket frames
+var codec = hotel.NewJSONCodec(messageRegistry)
+
 // Initialize message types
 func init() {
 	messageRegistry.Register(
@@ -86,6 +90,7 @@ func serveWs(w http.ResponseWriter, r *http.Request) {
 		log.Println("Upgrade error:", err)
 		return
 	}
+	conn.SetReadLimit(maxMessageSize)
 
 	// Get room ID from path
 	pathSegments := strings.Split(r.URL.Path, "/")
@@ -129,28 +134,23 @@ func serveWs(w http.ResponseWriter, r *http.Request) {
 					return
 				}
 
-				msg, err := parseWebSocketMessage(rawMsg)
-				if err != nil {
-					log.Printf("Message parse error: %v", err)
-					continue
+				// Decode errors are also emitted to the room handler.
+				if err := room.HandleClientBytes(client, rawMsg); err != nil {
+					log.Printf("Message handling error: %v", err)
 				}
-
-				room.HandleClientData(client, msg)
 			}
 		}
 	}()
 
 	// Handle outgoing messages to WebSocket
+	messageType := websocket.TextMessage
+	if room.Codec().Binary() {
+		messageType = websocket.BinaryMessage
+	}
 	go func() {
 		defer conn.Close()
-		for msg := range client.Receive() {
-			data, err := formatWebSocketMessage(msg)
-			if err != nil {
-				log.Printf("Message format error: %v", err)
-				continue
-			}
-
-			err = conn.WriteMessage(websocket.TextMessage, data)
+		for data := range client.ReceiveBytes() {
+			err := conn.WriteMessage(messageType, data)
 			if err != nil {
 				log.Println("Write error:", err)
 				return
@@ -171,65 +171,32 @@ func roomInit(ctx context.Context, id string) (*RoomMetadata, error) {
 func roomHandler(ctx context.Context, room *hotel.Room[RoomMetadata, UserMetadata, hotel.Message]) {
 	log.Printf("Room %s started", room.ID())
 
-	for {
-		select {
-		case event := <-room.Events():
-			switch event.Type {
-			case hotel.EventJoin:
-				// A client joined the room.
-				name := event.Client.Metadata().Name
-				log.Printf("%s joined room %s", name, room.ID())
-				room.BroadcastExcept(event.Client, &JoinMessage{Name: name})
-			case hotel.EventLeave:
-				// A client left the room.
-				name := event.Client.Metadata().Name
-				log.Printf("%s left room %s", name, room.ID())
-				room.BroadcastExcept(event.Client, &LeaveMessage{Name: name})
-			case hotel.EventCustom:
-				// Incoming message from a client.
-				switch msg := event.Data.(type) {
-				case *ChatMessage:
-					log.Printf("<%s> in %s: %s", event.Client.Metadata().Name, room.ID(), msg.Content)
-					room.BroadcastExcept(event.Client, event.Data)
-				default:
-					log.Printf("Unhandled message type: %T", msg)
-				}
-			}
-		case <-ctx.Done():
-			// Handler context canceled, perform cleanup
-			log.Printf("Handler for room %s is exiting", room.ID())
-			return
-		}
-	}
-}
-
-// formatWebSocketMessage formats a message for websocket transmission
-func formatWebSocketMessage(msg hotel.Message) ([]byte, error) {
-	payload, err := json.Marshal(msg)
-	if err != nil {
-		return nil, fmt.Errorf("marshal error: %v", err)
-	}
-	return []byte(fmt.Sprintf("%s %s", msg.Type(), string(payload))), nil
-}
-
-// parseWebSocketMessage parses a websocket message into a hotel.Message
-func parseWebSocketMessage(data []byte) (hotel.Message, error) {
-	parts := strings.SplitN(string(data), " ", 2)
-	if len(parts) != 2 {
-		return nil, fmt.Errorf("invalid message format: %s", string(data))
-	}
-
-	msgType := parts[0]
-	payload := []byte(parts[1])
-
-	msg, err := messageRegistry.Create(msgType)
-	if err != nil {
-		return nil, fmt.Errorf("message creation error: %v", err)
-	}
+	messages := hotel.NewDispatcher[UserMetadata, hotel.Message]()
+	hotel.On(messages, func(client *hotel.Client[UserMetadata, hotel.Message], msg *ChatMessage) {
+		log.Printf("<%s> in %s: %s", client.Metadata().Name, room.ID(), msg.Content)
+		room.BroadcastExcept(client, msg)
+	})
+	messages.Default(func(client *hotel.Client[UserMetadata, hotel.Message], msg hotel.Message) {
+		log.Printf("Unhandled message type: %T", msg)
+	})
 
-	if err := json.Unmarshal(payload, msg); err != nil {
-		return nil, fmt.Errorf("unmarshal error: %v", err)
+	// The events channel is closed once the room has closed.
+	for event := range room.Events() {
+		switch event.Type {
+		case hotel.EventJoin:
+			// A client joined the room.
+			name := event.Client.Metadata().Name
+			log.Printf("%s joined room %s", name, room.ID())
+			room.BroadcastExcept(event.Client, &JoinMessage{Name: name})
+		case hotel.EventLeave:
+			// A client left the room.
+			name := event.Client.Metadata().Name
+			log.Printf("%s left room %s", name, room.ID())
+			room.BroadcastExcept(event.Client, &LeaveMessage{Name: name})
+		case hotel.EventCustom:
+			// Incoming message from a client.
+			messages.Dispatch(event)
+		}
 	}
-
-	return msg, nil
+	log.Printf("Handler for room %s is exiting", room.ID())
 }
@@ -86,7 +86,7 @@ func testRoom(t *testing.T, roomID string) {
 							return
 						}
 
-						msg, err := parseWebSocketMessage(data)
+						msg, err := codec.Decode(data)
 						if err != nil {
 							errChan <- fmt.Errorf("client %d parse error: %v", i, err)
 							cancel()
@@ -118,7 +118,7 @@ func testRoom(t *testing.T, roomID string) {
 					Content: fmt.Sprintf("Message %d from %s", j, userID),
 				}
 
-				data, err := formatWebSocketMessage(msg)
+				data, err := codec.Encode(msg)
 				if err != nil {
 					t.Errorf("Failed to format message: %v", err)
 					return